@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// gitArchive keeps the latest output of every command in a git repository,
+// one directory per device and one file per command, so each run that
+// changes something becomes a commit:
+//
+//	mx1/show_configuration_display_set.txt
+//	mx1/show_version.txt
+//	mx2/...
+type gitArchive struct {
+	Dir string
+	// Operator is named in every commit message
+	Operator string
+}
+
+// defaultOperator is the local user running the collector
+func defaultOperator() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// git runs a git command inside the archive and returns its trimmed output
+func (a gitArchive) git(args ...string) (string, error) {
+
+	cmd := exec.Command("git", append([]string{"-C", a.Dir}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// open makes sure the archive directory exists and is a git repository
+func (a gitArchive) open() error {
+
+	if _, err := exec.LookPath("git"); err != nil {
+		return fmt.Errorf("the archive needs git: %w", err)
+	}
+	if err := os.MkdirAll(a.Dir, 0755); err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(a.Dir, ".git")); err == nil {
+		return nil
+	}
+	_, err := a.git("init", "--quiet")
+	return err
+}
+
+// update replaces a device's files with the outputs in its record. Commands
+// that failed keep their previous output, and files for commands no longer
+// collected are only removed when every command succeeded.
+func (a gitArchive) update(record *deviceRecord) error {
+
+	dir := filepath.Join(a.Dir, record.Hostname)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	current := map[string]bool{}
+	for _, result := range record.Commands {
+		name := slugCommand(result.Command) + ".txt"
+		for n := 2; current[name]; n++ {
+			name = fmt.Sprintf("%s_%d.txt", slugCommand(result.Command), n)
+		}
+		current[name] = true
+		if result.Error != "" {
+			continue
+		}
+		// Unlike snapshots these files are meant to be replaced, git keeps the history
+		output := strings.TrimRight(result.Output, "\n") + "\n"
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(output), 0644); err != nil {
+			return err
+		}
+	}
+
+	if record.Error != "" {
+		return nil
+	}
+	existing, err := filepath.Glob(filepath.Join(dir, "*.txt"))
+	if err != nil {
+		return err
+	}
+	for _, path := range existing {
+		if !current[filepath.Base(path)] {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// commit records everything the run changed, returning the devices whose
+// files changed. Nothing is committed when no device changed.
+func (a gitArchive) commit(runID string) ([]string, error) {
+
+	if _, err := a.git("add", "--all", "."); err != nil {
+		return nil, err
+	}
+	staged, err := a.git("diff", "--cached", "--name-only")
+	if err != nil {
+		return nil, err
+	}
+	if staged == "" {
+		return nil, nil
+	}
+
+	devices := map[string]bool{}
+	for _, path := range strings.Split(staged, "\n") {
+		devices[strings.SplitN(path, "/", 2)[0]] = true
+	}
+	changed := make([]string, 0, len(devices))
+	for device := range devices {
+		changed = append(changed, device)
+	}
+	sort.Strings(changed)
+
+	message := fmt.Sprintf("Run %s: %d device(s) changed\n\nChanged: %s\nRun-ID: %s\nOperator: %s\n",
+		runID, len(changed), strings.Join(changed, ", "), runID, a.Operator)
+
+	// Fall back to an identity of our own where git has none configured, so
+	// an unattended host can still commit
+	args := []string{}
+	if email, _ := a.git("config", "user.email"); email == "" {
+		hostname, _ := os.Hostname()
+		args = append(args, "-c", "user.name=configcollector", "-c", "user.email=configcollector@"+hostname)
+	}
+	args = append(args, "commit", "--quiet", "--message", message)
+	if _, err := a.git(args...); err != nil {
+		return nil, err
+	}
+	return changed, nil
+}
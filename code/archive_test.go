@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGitArchive(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	archive := gitArchive{Dir: filepath.Join(t.TempDir(), "archive"), Operator: "alice"}
+	if err := archive.open(); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(runID string, outputs map[string]string) []string {
+		t.Helper()
+		for _, host := range []string{"mx1", "mx2"} {
+			record := newDeviceRecord(Device{Hostname: host}, time.Now())
+			record.addCommand("show configuration | display set", outputs[host], nil, time.Now())
+			record.finish(nil)
+			if err := archive.update(record); err != nil {
+				t.Fatal(err)
+			}
+		}
+		changed, err := archive.commit(runID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return changed
+	}
+
+	if changed := run("run1", map[string]string{"mx1": "set a", "mx2": "set a"}); strings.Join(changed, ",") != "mx1,mx2" {
+		t.Errorf("first run changed %v", changed)
+	}
+	if changed := run("run2", map[string]string{"mx1": "set b", "mx2": "set a"}); strings.Join(changed, ",") != "mx1" {
+		t.Errorf("second run changed %v", changed)
+	}
+	if changed := run("run3", map[string]string{"mx1": "set b", "mx2": "set a"}); len(changed) != 0 {
+		t.Errorf("an identical run changed %v", changed)
+	}
+
+	log, err := archive.git("log", "--format=%B")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(log, "Run-ID:") != 2 || !strings.Contains(log, "Run run2: 1 device(s) changed\n\nChanged: mx1\nRun-ID: run2\nOperator: alice") {
+		t.Errorf("git log:\n%s", log)
+	}
+
+	content, err := os.ReadFile(filepath.Join(archive.Dir, "mx1", "show_configuration_display_set.txt"))
+	if err != nil || string(content) != "set b\n" {
+		t.Errorf("mx1 file = %q, %v", content, err)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Run modes
+const (
+	// modeCollect sends the command sets, as the collector always has
+	modeCollect = "collect"
+	// modeBackup pulls each device's configuration and keeps it as its backup
+	modeBackup = "backup"
+)
+
+// backupCommand is one form of the configuration pulled in backup mode
+type backupCommand struct {
+	Command string
+	// File is the backup's name under the device's backup directory
+	File string
+	// normalise strips what changes between captures of the same config
+	normalise func(string) string
+}
+
+var junosBackupCommands = []backupCommand{
+	{"show configuration | display set", "config.set", normaliseJunosText},
+	{"show configuration | display xml", "config.xml", normaliseJunosXML},
+	{"show configuration | display json", "config.json", normaliseJunosJSON},
+}
+
+// backupCommands maps a platform to the commands backup mode sends it
+var backupCommands = map[string][]backupCommand{
+	"juniper_junos": junosBackupCommands,
+}
+
+// backupCommandsFor returns the commands backup mode sends a device
+func backupCommandsFor(device Device) ([]string, error) {
+
+	// The CLI commands would come back wrapped in NETCONF replies, not the
+	// set, XML and JSON files the backups are
+	if device.Transport == transportNetconf {
+		return nil, fmt.Errorf("backup mode only supports devices over ssh, not %s", device.Transport)
+	}
+	backups, ok := backupCommands[device.Platform]
+	if !ok {
+		return nil, fmt.Errorf("backup mode does not support platform %s", device.Platform)
+	}
+	commands := make([]string, len(backups))
+	for i, backup := range backups {
+		commands[i] = backup.Command
+	}
+	return commands, nil
+}
+
+var (
+	junosTimestampLine = regexp.MustCompile(`(?m)^## Last (commit|changed): .*\n?`)
+	junosCommitAttr    = regexp.MustCompile(`\s+junos:commit-(seconds|localtime|user)="[^"]*"`)
+	junosCLIBlock      = regexp.MustCompile(`(?s)\s*<cli>.*?</cli>`)
+	junosCommitKey     = regexp.MustCompile(`"junos:commit-(seconds|localtime|user)"\s*:\s*"[^"]*"\s*,?`)
+	trailingComma      = regexp.MustCompile(`,(\s*})`)
+)
+
+// normaliseJunosText drops the "## Last commit" header from display set output
+func normaliseJunosText(output string) string {
+	return strings.TrimSpace(junosTimestampLine.ReplaceAllString(output, "")) + "\n"
+}
+
+// normaliseJunosXML drops the commit time and user attributes and the CLI banner
+func normaliseJunosXML(output string) string {
+	output = junosCommitAttr.ReplaceAllString(output, "")
+	output = junosCLIBlock.ReplaceAllString(output, "")
+	return normaliseJunosText(output)
+}
+
+// normaliseJunosJSON drops the commit time and user from the configuration's
+// "@" attributes, leaving the output alone if that would break the JSON
+func normaliseJunosJSON(output string) string {
+	normalised := junosCommitKey.ReplaceAllString(output, "")
+	normalised = trailingComma.ReplaceAllString(normalised, "$1")
+	if !json.Valid([]byte(normalised)) {
+		return strings.TrimSpace(output) + "\n"
+	}
+	return strings.TrimSpace(normalised) + "\n"
+}
+
+// normaliseBackup normalises the outputs of a backup mode record in place
+func normaliseBackup(record *deviceRecord) {
+
+	for i, result := range record.Commands {
+		for _, backup := range backupCommands[record.Platform] {
+			if backup.Command == result.Command && result.Error == "" {
+				record.Commands[i].Output = backup.normalise(result.Output)
+			}
+		}
+	}
+}
+
+// writeBackup stores a backup mode record as the device's canonical backup,
+// <dir>/<hostname>/config.set and so on. Each file is replaced whole, and a
+// command that failed leaves its previous backup in place.
+func writeBackup(dir string, record *deviceRecord) ([]string, error) {
+
+	hostDir := filepath.Join(dir, record.Hostname)
+	if err := os.MkdirAll(hostDir, 0755); err != nil {
+		return nil, err
+	}
+
+	written := []string{}
+	for _, result := range record.Commands {
+		if result.Error != "" {
+			continue
+		}
+		for _, backup := range backupCommands[record.Platform] {
+			if backup.Command != result.Command {
+				continue
+			}
+			path := filepath.Join(hostDir, backup.File)
+			if err := replaceFile(path, []byte(result.Output)); err != nil {
+				return written, err
+			}
+			written = append(written, path)
+		}
+	}
+	return written, nil
+}
+
+// replaceFile writes content alongside path and renames it into place, so a
+// reader never sees half a backup
+func replaceFile(path string, content []byte) error {
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const junosSetOutput = `## Last commit: 2026-10-18 10:15:30 UTC by netops
+set version 21.4R3.15
+set system host-name mx1
+`
+
+const junosXMLOutput = `<rpc-reply xmlns:junos="http://xml.juniper.net/junos/21.4R3/junos">
+    <configuration junos:commit-seconds="1792318530" junos:commit-localtime="2026-10-18 10:15:30 UTC" junos:commit-user="netops">
+            <version>21.4R3.15</version>
+            <system>
+                <host-name>mx1</host-name>
+            </system>
+    </configuration>
+    <cli>
+        <banner></banner>
+    </cli>
+</rpc-reply>
+`
+
+const junosJSONOutput = `{
+    "configuration" : {
+        "@" : {
+            "junos:commit-seconds" : "1792318530",
+            "junos:commit-localtime" : "2026-10-18 10:15:30 UTC",
+            "junos:commit-user" : "netops"
+        },
+        "version" : "21.4R3.15",
+        "system" : {
+            "host-name" : "mx1"
+        }
+    }
+}
+`
+
+func TestNormaliseJunosBackups(t *testing.T) {
+	set := normaliseJunosText(junosSetOutput)
+	if set != "set version 21.4R3.15\nset system host-name mx1\n" {
+		t.Errorf("set = %q", set)
+	}
+
+	xml := normaliseJunosXML(junosXMLOutput)
+	if strings.Contains(xml, "commit-") || strings.Contains(xml, "<cli>") || !strings.Contains(xml, "<configuration>") {
+		t.Errorf("xml =\n%s", xml)
+	}
+
+	normalised := normaliseJunosJSON(junosJSONOutput)
+	if strings.Contains(normalised, "commit-") || !json.Valid([]byte(normalised)) {
+		t.Errorf("json =\n%s", normalised)
+	}
+
+	// The same config committed later by someone else normalises the same
+	later := strings.NewReplacer("10:15:30", "11:00:00", "1792318530", "1792321200", "netops", "alice").Replace(junosXMLOutput)
+	if normaliseJunosXML(later) != xml {
+		t.Error("xml differs after only a new commit")
+	}
+}
+
+func TestWriteBackup(t *testing.T) {
+	if _, err := backupCommandsFor(Device{Platform: "cisco_iosxe"}); err == nil {
+		t.Error("want an error for a platform with no backup commands")
+	}
+	if _, err := backupCommandsFor(Device{Platform: "juniper_junos", Transport: transportNetconf}); err == nil {
+		t.Error("want an error for a NETCONF device")
+	}
+
+	device := Device{Hostname: "mx1", Platform: "juniper_junos"}
+	commands, err := backupCommandsFor(device)
+	if err != nil || len(commands) != 3 {
+		t.Fatalf("backupCommandsFor() = %q, %v", commands, err)
+	}
+
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "mx1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "mx1", "config.xml"), []byte("previous\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	record := newDeviceRecord(device, time.Now())
+	record.addCommand(commands[0], junosSetOutput, nil, time.Now())
+	record.addCommand(commands[1], "", os.ErrDeadlineExceeded, time.Now())
+	record.addCommand(commands[2], junosJSONOutput, nil, time.Now())
+	normaliseBackup(record)
+
+	written, err := writeBackup(dir, record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != 2 {
+		t.Errorf("written = %q", written)
+	}
+	set, _ := os.ReadFile(filepath.Join(dir, "mx1", "config.set"))
+	if strings.Contains(string(set), "Last commit") {
+		t.Errorf("config.set was not normalised:\n%s", set)
+	}
+	xml, _ := os.ReadFile(filepath.Join(dir, "mx1", "config.xml"))
+	if string(xml) != "previous\n" {
+		t.Errorf("a failed command replaced the previous backup: %q", xml)
+	}
+}
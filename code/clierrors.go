@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Classes a command the device rejected is sorted into
+const (
+	cliErrorSyntax     = "syntax"
+	cliErrorIncomplete = "incomplete"
+	cliErrorAmbiguous  = "ambiguous"
+	cliErrorPermission = "permission"
+	// cliErrorDevice is any other error the device reported
+	cliErrorDevice = "error"
+)
+
+// cliErrorPattern is a line of output that shows the device refused a command
+type cliErrorPattern struct {
+	Class   string
+	pattern *regexp.Regexp
+}
+
+func cliPattern(class string, pattern string) cliErrorPattern {
+	return cliErrorPattern{class, regexp.MustCompile(pattern)}
+}
+
+// Patterns are anchored to the start of a line so output that merely mentions
+// an error, such as a log, isn't taken for one
+var (
+	junosErrors = []cliErrorPattern{
+		cliPattern(cliErrorSyntax, `(?m)^\s*syntax error\b.*$`),
+		cliPattern(cliErrorSyntax, `(?m)^\s*unknown command\b.*$`),
+		cliPattern(cliErrorIncomplete, `(?m)^\s*missing argument\b.*$`),
+		cliPattern(cliErrorAmbiguous, `(?m)^\s*'.*' is ambiguous\b.*$`),
+		cliPattern(cliErrorPermission, `(?m)^\s*(error: )?permission denied\b.*$`),
+		cliPattern(cliErrorDevice, `(?m)^\s*error: .*$`),
+	}
+	ciscoErrors = []cliErrorPattern{
+		cliPattern(cliErrorSyntax, `(?m)^% Invalid (input|command)\b.*$`),
+		cliPattern(cliErrorSyntax, `(?m)^% Unknown command\b.*$`),
+		cliPattern(cliErrorIncomplete, `(?m)^% Incomplete command\b.*$`),
+		cliPattern(cliErrorAmbiguous, `(?m)^% Ambiguous command\b.*$`),
+		cliPattern(cliErrorPermission, `(?m)^% (Authorization denied|Permission denied)\b.*$`),
+	}
+	srosErrors = []cliErrorPattern{
+		cliPattern(cliErrorSyntax, `(?m)^\s*Error: (Bad command|Invalid)\b.*$`),
+		cliPattern(cliErrorPermission, `(?m)^\s*MINOR: CLI .*not allowed\b.*$`),
+		cliPattern(cliErrorDevice, `(?m)^\s*(MINOR|MAJOR|CRITICAL): CLI .*$`),
+	}
+)
+
+// cliErrorPatterns maps a platform to the patterns checked against every
+// command's output. Platforms not listed rely on scrapligo's own failure
+// strings alone.
+var cliErrorPatterns = map[string][]cliErrorPattern{
+	"juniper_junos":      junosErrors,
+	"cisco_iosxe":        ciscoErrors,
+	"cisco_iosxr":        ciscoErrors,
+	"cisco_nxos":         ciscoErrors,
+	"arista_eos":         ciscoErrors,
+	"nokia_sros":         srosErrors,
+	"nokia_sros_classic": srosErrors,
+}
+
+// cliError is a command the device answered with an error
+type cliError struct {
+	Class string
+	// Line is the line of output the error was recognised by
+	Line string
+}
+
+func (e *cliError) Error() string {
+	return fmt.Sprintf("%s [%s]", e.Line, e.Class)
+}
+
+func (e *cliError) Unwrap() error {
+	return errCommandRejected
+}
+
+// classifyCLIError returns the error in a command's output on a platform, or
+// nil if the output shows none
+func classifyCLIError(platform string, output string) *cliError {
+
+	for _, p := range cliErrorPatterns[platform] {
+		if line := p.pattern.FindString(output); line != "" {
+			return &cliError{Class: p.Class, Line: strings.TrimSpace(line)}
+		}
+	}
+	return nil
+}
+
+// printRejectedCommands lists the commands devices refused, so a typo in a
+// command set stands out instead of hiding in an output file
+func printRejectedCommands(records []*deviceRecord) {
+
+	for _, record := range records {
+		for _, result := range record.Commands {
+			if result.Rejected {
+				fmt.Printf("%s: %q rejected: %s\n", record.Hostname, result.Command, result.Error)
+			}
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestClassifyCLIError(t *testing.T) {
+	cases := []struct {
+		platform, output, class, line string
+	}{
+		{"juniper_junos", "                    ^\nsyntax error, expecting <command>.\n", cliErrorSyntax, "syntax error, expecting <command>."},
+		{"juniper_junos", "                 ^\nunknown command.\n", cliErrorSyntax, "unknown command."},
+		{"juniper_junos", "error: configuration database locked by:\n  netops terminal p0\n", cliErrorDevice, "error: configuration database locked by:"},
+		{"juniper_junos", "'int' is ambiguous.\nPossible completions:\n", cliErrorAmbiguous, "'int' is ambiguous."},
+		{"cisco_iosxe", "                  ^\n% Invalid input detected at '^' marker.\n", cliErrorSyntax, "% Invalid input detected at '^' marker."},
+		{"arista_eos", "% Incomplete command\n", cliErrorIncomplete, "% Incomplete command"},
+		{"nokia_sros", "MINOR: CLI Command not allowed for this user.\n", cliErrorPermission, "MINOR: CLI Command not allowed for this user."},
+		// Output that only mentions an error is not one
+		{"juniper_junos", "Oct 18 10:15:30  mx1 rpd[1234]: error: BGP peer 10.0.0.2 reset\n", "", ""},
+		{"cisco_iosxe", "Interface GigabitEthernet1 % Invalid input counter 0\n", "", ""},
+		// Platforms with no patterns leave it to scrapligo
+		{"paloalto_panos", "Invalid syntax.\n", "", ""},
+	}
+	for _, c := range cases {
+		got := classifyCLIError(c.platform, c.output)
+		if c.class == "" {
+			if got != nil {
+				t.Errorf("%s %q: got %+v, want no error", c.platform, c.output, got)
+			}
+			continue
+		}
+		if got == nil || got.Class != c.class || got.Line != c.line {
+			t.Errorf("%s %q: got %+v, want %s %q", c.platform, c.output, got, c.class, c.line)
+		}
+	}
+}
+
+func TestCLIErrorIsRejected(t *testing.T) {
+	err := classifyCLIError("juniper_junos", "syntax error, expecting <command>.\n")
+	if !errors.Is(err, errCommandRejected) || errorClass(err) != errorClassOther {
+		t.Errorf("err = %v, class %s", err, errorClass(err))
+	}
+
+	// A command the user may not run is not an authentication failure
+	denied := classifyCLIError("juniper_junos", "error: permission denied\n")
+	if denied == nil || denied.Class != cliErrorPermission || errorClass(denied) == errorClassAuth {
+		t.Errorf("denied = %+v, class %s", denied, errorClass(denied))
+	}
+
+	record := newDeviceRecord(Device{Hostname: "mx1", Platform: "juniper_junos"}, time.Now())
+	record.addReply("show bogus", commandReply{Output: "syntax error, expecting <command>.\n"}, err, time.Now())
+	if got := record.Commands[0]; !got.Rejected || got.ErrorClass != cliErrorSyntax || got.Error != "syntax error, expecting <command>. [syntax]" {
+		t.Errorf("result = %+v", got)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultCommandSet is the set name applied to every device before any other
+const defaultCommandSet = "default"
+
+// commandSet is one file in the commands directory. A .txt file is just a
+// list of commands; a .yaml file may also inherit other sets, exclude
+// commands gathered so far, or replace them outright:
+//
+//	inherit: [bgp]
+//	commands:
+//	  - show mpls lsp
+//	exclude:
+//	  - file list /var/tmp
+//	replace: false
+type commandSet struct {
+	Name     string   `yaml:"-"`
+	File     string   `yaml:"-"`
+	Inherit  []string `yaml:"inherit"`
+	Commands []string `yaml:"commands"`
+	Exclude  []string `yaml:"exclude"`
+	Replace  bool     `yaml:"replace"`
+}
+
+// commandSets holds every named command set plus the fallback list
+type commandSets struct {
+	// fallback is sent to devices that no named set applies to
+	fallback []string
+	// sets is keyed by file name without extension, e.g. "junos-core"
+	sets map[string]*commandSet
+}
+
+// loadCommandSets reads every .txt and .yaml file in dir as a named command
+// set, plus the fallback file if one is given. There must be commands in at
+// least one of them.
+func loadCommandSets(fallbackFile string, dir string) (*commandSets, error) {
+
+	c := &commandSets{sets: map[string]*commandSet{}}
+
+	if fallbackFile != "" {
+		if _, err := os.Stat(fallbackFile); err != nil {
+			return nil, fmt.Errorf("fallback command file: %w", err)
+		}
+		c.fallback = fileToSlice(fallbackFile)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		ext := filepath.Ext(file)
+		name := strings.TrimSuffix(filepath.Base(file), ext)
+
+		set := &commandSet{Name: name, File: file}
+		switch ext {
+		case ".txt":
+			set.Commands = fileToSlice(file)
+		case ".yaml", ".yml":
+			content, err := os.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			// Unknown keys are refused, so a typo such as "comands:" can't
+			// quietly leave the set empty. An empty file is an empty set.
+			decoder := yaml.NewDecoder(bytes.NewReader(content))
+			decoder.KnownFields(true)
+			if err := decoder.Decode(set); err != nil && err != io.EOF {
+				return nil, fmt.Errorf("%s: %w", file, err)
+			}
+		default:
+			continue
+		}
+
+		if existing, ok := c.sets[name]; ok {
+			return nil, fmt.Errorf("%s: command set %q is already defined in %s", file, name, existing.File)
+		}
+		c.sets[name] = set
+	}
+
+	// Check inheritance up front so a typo fails the run before any device is touched
+	for _, name := range c.names() {
+		if _, err := c.expand(name, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.fallback == nil && len(c.sets) == 0 {
+		return nil, fmt.Errorf("no command sets found in %s and no fallback file given with -commands", dir)
+	}
+	return c, nil
+}
+
+func (c *commandSets) names() []string {
+	names := make([]string, 0, len(c.sets))
+	for name := range c.sets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// expand returns a set's commands with everything it inherits merged in ahead
+// of them, minus the set's excludes. chain tracks the sets being expanded
+// so inheritance loops are reported instead of recursing forever.
+func (c *commandSets) expand(name string, chain []string) ([]string, error) {
+
+	for _, seen := range chain {
+		if seen == name {
+			return nil, fmt.Errorf("command set inheritance loop: %s -> %s", strings.Join(chain, " -> "), name)
+		}
+	}
+	set, ok := c.sets[name]
+	if !ok {
+		return nil, fmt.Errorf("command set %q inherits unknown set %q", chain[len(chain)-1], name)
+	}
+	chain = append(chain, name)
+
+	commands := []string{}
+	for _, parent := range set.Inherit {
+		inherited, err := c.expand(parent, chain)
+		if err != nil {
+			return nil, err
+		}
+		commands = mergeCommands(commands, inherited)
+	}
+	commands = mergeCommands(commands, set.Commands)
+	return removeCommands(commands, set.Exclude), nil
+}
+
+// removeCommands drops every command listed in exclude
+func removeCommands(commands []string, exclude []string) []string {
+
+	if len(exclude) == 0 {
+		return commands
+	}
+	excluded := map[string]bool{}
+	for _, cmd := range exclude {
+		excluded[cmd] = true
+	}
+	kept := []string{}
+	for _, cmd := range commands {
+		if !excluded[cmd] {
+			kept = append(kept, cmd)
+		}
+	}
+	return kept
+}
+
+// mergeCommands appends extra to commands, skipping any already present so a
+// command shared by two sets is only sent once
+func mergeCommands(commands []string, extra []string) []string {
+
+	present := map[string]bool{}
+	for _, cmd := range commands {
+		present[cmd] = true
+	}
+	for _, cmd := range extra {
+		if !present[cmd] {
+			commands = append(commands, cmd)
+			present[cmd] = true
+		}
+	}
+	return commands
+}
+
+// setNamesFor lists the set names that could apply to a device, from the most
+// general to the most specific:
+//
+//	default
+//	<platform>           e.g. juniper_junos or its alias junos
+//	<group>              for each inventory group, in order
+//	<platform>-<group>   e.g. junos-core
+//	<role>, <platform>-<role>  from the device's "role" var
+func setNamesFor(device Device) []string {
+
+	platforms := []string{device.Platform}
+	for alias, canonical := range platformAliases {
+		if canonical == device.Platform {
+			platforms = append(platforms, alias)
+		}
+	}
+	sort.Strings(platforms[1:])
+
+	scopes := append([]string{}, device.Groups...)
+	if role, ok := device.Vars["role"].(string); ok && role != "" {
+		scopes = append(scopes, role)
+	}
+
+	names := []string{defaultCommandSet}
+	names = append(names, platforms...)
+	for _, scope := range scopes {
+		names = append(names, scope)
+		for _, platform := range platforms {
+			names = append(names, platform+"-"+scope)
+		}
+	}
+	return names
+}
+
+// appliedSets returns the names of the sets that exist for a device, in merge order
+func (c *commandSets) appliedSets(device Device) []string {
+
+	applied := []string{}
+	seen := map[string]bool{}
+	for _, name := range setNamesFor(device) {
+		if _, ok := c.sets[name]; ok && !seen[name] {
+			applied = append(applied, name)
+			seen[name] = true
+		}
+	}
+	return applied
+}
+
+// forDevice merges every set that applies to a device, general sets first, so
+// PE routers pick up BGP/MPLS commands on top of their platform's commands.
+// Devices that no set applies to get the fallback list.
+func (c *commandSets) forDevice(device Device) ([]string, error) {
+
+	applied := c.appliedSets(device)
+	if len(applied) == 0 {
+		return c.fallback, nil
+	}
+
+	commands := []string{}
+	for _, name := range applied {
+		expanded, err := c.expand(name, nil)
+		if err != nil {
+			return nil, err
+		}
+		// Replace and exclude also reach back to what more general sets contributed
+		set := c.sets[name]
+		if set.Replace {
+			commands = []string{}
+		}
+		commands = removeCommands(mergeCommands(commands, expanded), set.Exclude)
+	}
+	return commands, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeCommandSets(t *testing.T, files map[string]string) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	fallback := ""
+	commandsDir := filepath.Join(dir, "commands")
+	if err := os.Mkdir(commandsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(commandsDir, name)
+		if name == "commands.txt" {
+			path = filepath.Join(dir, name)
+			fallback = path
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return fallback, commandsDir
+}
+
+func TestCommandSetsForDevice(t *testing.T) {
+	fallback, dir := writeCommandSets(t, map[string]string{
+		"commands.txt":    "show version\n",
+		"junos.txt":       "show version\nshow interfaces terse\nfile list /var/tmp\n",
+		"cisco_iosxe.txt": "show ip interface brief\n",
+		"bgp.yaml":        "commands:\n  - show bgp summary\n",
+		"junos-pe.yaml":   "inherit: [bgp]\ncommands:\n  - show mpls lsp\nexclude:\n  - file list /var/tmp\n",
+		"access.yaml":     "replace: true\ncommands:\n  - show lldp neighbors\n  - show vlans\n",
+	})
+
+	sets, err := loadCommandSets(fallback, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		device Device
+		want   []string
+	}{
+		{Device{Platform: "juniper_junos"}, []string{"show version", "show interfaces terse", "file list /var/tmp"}},
+		{Device{Platform: "juniper_junos", Groups: []string{"pe"}},
+			[]string{"show version", "show interfaces terse", "show bgp summary", "show mpls lsp"}},
+		{Device{Platform: "juniper_junos", Vars: map[string]interface{}{"role": "access"}},
+			[]string{"show lldp neighbors", "show vlans"}},
+		{Device{Platform: "cisco_iosxe", Groups: []string{"pe"}}, []string{"show ip interface brief"}},
+		{Device{Platform: "arista_eos"}, []string{"show version"}},
+	}
+	for _, c := range cases {
+		got, err := sets.forDevice(c.device)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("forDevice(%+v) = %q, want %q", c.device, got, c.want)
+		}
+	}
+}
+
+func TestCommandSetsInheritanceErrors(t *testing.T) {
+	fallback, dir := writeCommandSets(t, map[string]string{
+		"a.yaml": "inherit: [b]\n",
+		"b.yaml": "inherit: [a]\n",
+	})
+	if _, err := loadCommandSets(fallback, dir); err == nil || !strings.Contains(err.Error(), "loop") {
+		t.Errorf("err = %v, want inheritance loop", err)
+	}
+
+	fallback, dir = writeCommandSets(t, map[string]string{
+		"a.yaml": "inherit: [missing]\n",
+	})
+	if _, err := loadCommandSets(fallback, dir); err == nil || !strings.Contains(err.Error(), "unknown set") {
+		t.Errorf("err = %v, want unknown set", err)
+	}
+
+	for _, content := range []string{"comands:\n  - show version\n", "inherits: [b]\n", "name: other\n"} {
+		fallback, dir = writeCommandSets(t, map[string]string{"a.yaml": content})
+		if _, err := loadCommandSets(fallback, dir); err == nil || !strings.Contains(err.Error(), "not found in type") {
+			t.Errorf("%q: err = %v, want an unknown field error", content, err)
+		}
+	}
+
+	// The fallback file is optional, but one that is named has to exist
+	_, dir = writeCommandSets(t, map[string]string{"junos.txt": "show version\n"})
+	if _, err := loadCommandSets("", dir); err != nil {
+		t.Errorf("no fallback: %v", err)
+	}
+	if _, err := loadCommandSets(filepath.Join(dir, "missing.txt"), dir); err == nil {
+		t.Error("want an error for a missing fallback file")
+	}
+}
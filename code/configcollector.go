@@ -1,62 +1,350 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"github.com/scrapli/scrapligo/driver/network"
 	"github.com/scrapli/scrapligo/driver/options"
 	"github.com/scrapli/scrapligo/platform"
+	"github.com/scrapli/scrapligo/response"
+	"github.com/scrapli/scrapligo/util"
 	"golang.org/x/term"
 	"log"
 	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
+// WriteStringToFile writes data to a new file, refusing to replace one that
+// already exists so an earlier snapshot is never lost
 func WriteStringToFile(filename, data string) error {
-	// Write the string data to the file named 'filename'
-	err := os.WriteFile(filename, []byte(data), 0644)
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
 	if err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("%s already exists, refusing to overwrite it", filename)
+		}
 		return err
 	}
+	_, err = file.WriteString(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// commandReply is a device's answer to one command
+type commandReply struct {
+	Output string
+	// Elapsed is the seconds the driver spent on the command, as it measured it
+	Elapsed float64
+}
+
+// deviceDriver is an open session to a device, over the CLI or NETCONF
+type deviceDriver interface {
+	// run sends one command and returns the device's reply to it. A reply
+	// showing the device refused the command comes with errCommandRejected.
+	run(cmd string) (commandReply, error)
+	// close ends the session politely
+	close()
+	// abort drops the connection without waiting on the device, for hung devices
+	abort()
+}
+
+// errCommandRejected marks a command the device answered with an error, as
+// opposed to one lost with the session
+var errCommandRejected = errors.New("device rejected the command")
+
+// cliDriver runs commands through the network driver, which keeps the session
+// at the platform's default privilege level and flags output matching the
+// platform's failure strings, e.g. "syntax error" or "unknown command". Output
+// is also checked against the collector's own cliErrorPatterns.
+type cliDriver struct {
+	d        *network.Driver
+	platform string
+}
+
+func (c cliDriver) run(cmd string) (commandReply, error) {
+
+	r, err := c.d.SendCommand(cmd)
+	if err != nil {
+		return commandReply{}, err
+	}
+	reply := commandReply{Output: r.Result, Elapsed: r.ElapsedTime}
+	if rejected := classifyCLIError(c.platform, r.Result); rejected != nil {
+		return reply, rejected
+	}
+	if r.Failed != nil {
+		var opErr *response.OperationError
+		if errors.As(r.Failed, &opErr) {
+			return reply, &cliError{Class: cliErrorDevice, Line: opErr.ErrorString}
+		}
+		return reply, fmt.Errorf("%w: %v", errCommandRejected, r.Failed)
+	}
+	return reply, nil
+}
+
+func (c cliDriver) close() { c.d.Close() }
+
+func (c cliDriver) abort() { c.d.Channel.Close() }
+
+// deviceSession guards the driver shared between a collection goroutine and its
+// deadline abort, since a retry may swap the driver out for a fresh one
+type deviceSession struct {
+	mu      sync.Mutex
+	driver  deviceDriver
+	aborted bool
+}
+
+// set stores a freshly opened driver, refusing it if the device was already aborted
+func (s *deviceSession) set(d deviceDriver) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.aborted {
+		d.abort()
+		return errDeviceTimeout
+	}
+	s.driver = d
 	return nil
 }
-func connectAndRunCmds(device_type string, hostName string, username string, password string, commands []string) (string, error) {
 
-	p, err := platform.NewPlatform(device_type, hostName, options.WithAuthNoStrictKey(), options.WithAuthUsername(username), options.WithAuthPassword(password))
+func (s *deviceSession) get() deviceDriver {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.driver
+}
+
+// close shuts the current driver down, if any, and forgets it
+func (s *deviceSession) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.driver != nil {
+		s.driver.close()
+		s.driver = nil
+	}
+}
+
+// abort drops the channel without the driver's polite logout, for hung devices
+func (s *deviceSession) abort() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.aborted = true
+	if s.driver != nil {
+		s.driver.abort()
+		s.driver = nil
+	}
+}
+
+// connectSettings holds the run-wide settings used when connecting to devices
+type connectSettings struct {
+	Limits   timeouts
+	Retry    retryPolicy
+	HostKeys hostKeyPolicy
+	Auth     sshAuth
+	// JumpConfig is the generated ssh_config describing jump hosts, see jumpConfig
+	JumpConfig string
+	// StopOnError skips a device's remaining commands once it rejects one
+	StopOnError bool
+	// Open starts a session to a device, openSession when not set
+	Open func(device Device, creds credentials, settings connectSettings) (deviceDriver, error)
+}
+
+// connectionOptions returns the scrapligo options used for every connection to a device
+func connectionOptions(device Device, creds credentials, settings connectSettings) []util.Option {
+	opts := []util.Option{
+		options.WithAuthUsername(creds.Username),
+		options.WithPort(device.Port),
+		options.WithTimeoutSocket(settings.Limits.Connect),
+		options.WithTimeoutOps(settings.Limits.Command),
+		options.WithSystemTransportOpenArgsOverride(sshOpenArgs(device, creds.Username, settings)),
+	}
+	if settings.Auth.usesPassword(device) {
+		opts = append(opts, options.WithAuthPassword(creds.Password))
+	}
+	// The key goes to ssh as -i rather than through WithAuthPrivateKey, which the
+	// system transport refuses for encrypted keys; scrapligo still answers the
+	// passphrase prompt for us
+	if passphrase := settings.Auth.Passphrases[settings.Auth.keyFor(device)]; passphrase != "" {
+		opts = append(opts, options.WithAuthPassphrase(passphrase))
+	}
+	return opts
+}
+
+func openDriver(device Device, creds credentials, settings connectSettings) (*network.Driver, error) {
+
+	p, err := platform.NewPlatform(device.Platform, device.Hostname, connectionOptions(device, creds, settings)...)
 	if err != nil {
-		return "", fmt.Errorf("failed to create platform %+v", err)
+		return nil, fmt.Errorf("failed to create platform %w", err)
 	}
 
 	d, err := p.GetNetworkDriver()
 	if err != nil {
-		return "", fmt.Errorf("failed to fetch network driver from the platform; error: %+v\n", err)
+		return nil, fmt.Errorf("failed to fetch network driver from the platform; error: %w", err)
 	}
 
 	err = d.Open()
 	if err != nil {
-		return "", fmt.Errorf("failed to open driver %+v", err)
+		return nil, fmt.Errorf("failed to open driver %w", settings.HostKeys.explain(device, err))
 	}
 
-	defer d.Close()
+	return d, nil
+}
 
-	all_output := ""
+// openSession opens a driver for the device's transport
+func openSession(device Device, creds credentials, settings connectSettings) (deviceDriver, error) {
 
-	for _, cmd := range commands {
-		output, err := d.Channel.SendInput(cmd)
+	if device.Transport == transportNetconf {
+		d, err := openNetconf(device, creds, settings)
 		if err != nil {
-			return "", fmt.Errorf("failed to send input to device %+v", err)
+			return nil, err
 		}
-		all_output += cmd + "\n"
-		all_output += "-----------------------------------\n"
-		all_output += string(output) + "\n"
-		all_output += "-------------------------------------------------------------------\n"
+		return netconfDriver{d}, nil
+	}
 
+	d, err := openDriver(device, creds, settings)
+	if err != nil {
+		return nil, err
 	}
+	return cliDriver{d, device.Platform}, nil
+}
+
+// commandSource picks the commands to send a device once its platform is known
+type commandSource func(device Device) ([]string, error)
 
-	file_err := WriteStringToFile(hostName+"_"+getCurrentTime()+".txt", all_output)
-	if file_err != nil {
-		return "", fmt.Errorf("failed to write to file %+v", err)
+// connectAndRunCmds collects from one device. The record is returned even when
+// err is set, holding whatever commands ran before collection failed; it is
+// nil if the device failed before any command was sent.
+func connectAndRunCmds(ctx context.Context, device Device, creds credentials, commandsFor commandSource, settings connectSettings) (*deviceRecord, error) {
+
+	retry := settings.Retry
+	started := time.Now()
+
+	// Work out the platform first when the inventory leaves it to us
+	if device.Platform == platformAuto {
+		probe := &deviceSession{}
+		err := runWithDeadline(ctx, func() error {
+			return retry.do(ctx, "platform detection", func() error {
+				platform, err := detectPlatform(probe, device, creds, settings)
+				device.Platform = platform
+				return err
+			})
+		}, probe.abort)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	commands, err := commandsFor(device)
+	if err != nil {
+		return nil, err
+	}
+	if len(commands) == 0 {
+		return nil, fmt.Errorf("no commands apply to %s (platform %s, groups %v)", device.Hostname, device.Platform, device.Groups)
+	}
+
+	// Render every command up front so a missing variable fails before any is sent
+	commands, err = renderCommands(device, commands)
+	if err != nil {
+		return nil, err
+	}
+
+	openDevice := settings.Open
+	if openDevice == nil {
+		openDevice = openSession
+	}
+	session := &deviceSession{}
+	open := func() error {
+		d, err := openDevice(device, creds, settings)
+		if err != nil {
+			return err
+		}
+		return session.set(d)
+	}
+
+	// The record is only touched by the collecting goroutine until it returns,
+	// so a timed out device hands back a copy of what had finished by then
+	var mu sync.Mutex
+	record := newDeviceRecord(device, started)
+	snapshot := func() *deviceRecord {
+		mu.Lock()
+		defer mu.Unlock()
+		copied := *record
+		copied.Commands = append([]commandResult{}, record.Commands...)
+		return &copied
+	}
+
+	// Open the session and run the commands, giving up once the device wall time is spent
+	err = runWithDeadline(ctx, func() error {
+		err := retry.do(ctx, "open", open)
+		if err != nil {
+			return err
+		}
+
+		defer session.close()
+
+		var failed error
+		failures := 0
+		for i, cmd := range commands {
+			var reply commandReply
+			cmdStart := time.Now()
+			err := retry.do(ctx, fmt.Sprintf("command %q", cmd), func() error {
+				// A previous attempt failed and dropped the session, so start a new one
+				d := session.get()
+				if d == nil {
+					if err := open(); err != nil {
+						return err
+					}
+					d = session.get()
+				}
+				if d == nil {
+					return errDeviceTimeout
+				}
+
+				var err error
+				reply, err = d.run(cmd)
+				if errors.Is(err, errCommandRejected) {
+					return err
+				}
+				if err != nil {
+					session.close()
+					return fmt.Errorf("failed to send input to device %w", err)
+				}
+				return nil
+			})
+			mu.Lock()
+			record.addReply(cmd, reply, err, cmdStart)
+			mu.Unlock()
+			if err == nil {
+				continue
+			}
+
+			// Carry on past a command the device choked on, but not past a lost
+			// session or a timeout, which the remaining commands would only repeat
+			if errorClass(err) != errorClassOther {
+				return err
+			}
+			failures++
+			if failed == nil {
+				failed = fmt.Errorf("command %q: %w", cmd, err)
+			}
+			if settings.StopOnError && errors.Is(err, errCommandRejected) {
+				return fmt.Errorf("stopped after command %d of %d, %q: %w", i+1, len(commands), cmd, err)
+			}
+		}
+		if failed != nil {
+			return fmt.Errorf("%d of %d commands failed, first %w", failures, len(commands), failed)
+		}
+		return nil
+	}, session.abort)
+
+	result := snapshot()
+	result.finish(err)
+	if len(result.Commands) == 0 {
+		return nil, err
 	}
-	return all_output, nil
+	return result, err
 }
 
 func fileToSlice(file string) []string {
@@ -88,22 +376,17 @@ func fileToSlice(file string) []string {
 	return file_list
 }
 
-func getCurrentTime() string {
-
-	// Get current time
-	currentTime := time.Now()
-
-	formattedTime := currentTime.Format("02-01-06@15.04")
-
-	return formattedTime
-}
+func getCreds(username string, needPassword bool) (string, string) {
 
-func getCreds() (string, string) {
+	// Get username and password from the user, skipping whatever is already known
+	if username == "" {
+		fmt.Print("Please enter your username: ")
+		fmt.Scan(&username)
+	}
 
-	// Get username and password from the user
-	var username string
-	fmt.Print("Please enter your username: ")
-	fmt.Scan(&username)
+	if !needPassword {
+		return username, ""
+	}
 
 	fmt.Print("Enter Password: ")
 	// Read password from terminal without echoing it back
@@ -112,6 +395,7 @@ func getCreds() (string, string) {
 	if error != nil {
 		log.Fatal(error)
 	}
+	fmt.Println()
 	// Turn password from bytes into string
 	passwordStr := string(password)
 
@@ -119,16 +403,365 @@ func getCreds() (string, string) {
 
 }
 
-func main() {
-	devices := fileToSlice("devices.txt")
-	commands := fileToSlice("commands.txt")
-	uname, pword := getCreds()
+// getPassphrases asks once for the passphrase of every encrypted key file
+func getPassphrases(keyFiles []string) map[string]string {
+
+	passphrases := map[string]string{}
+	for _, keyFile := range keyFiles {
+		if _, done := passphrases[keyFile]; done {
+			continue
+		}
+		encrypted, err := keyNeedsPassphrase(keyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !encrypted {
+			continue
+		}
+
+		fmt.Printf("Enter passphrase for %s: ", keyFile)
+		passphrase, err := term.ReadPassword(0)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println()
+		passphrases[keyFile] = string(passphrase)
+	}
+	return passphrases
+}
+
+func printSummary(verb string, results []deviceResult) int {
+
+	// Count devices per failure class and list every device that failed
+	counts := map[string]int{}
+	for _, result := range results {
+		counts[failureClass(result.Err)]++
+	}
+
+	fmt.Printf("\n%s %d/%d devices (%d timed out, %d failed)\n",
+		verb, counts[classOK], len(results), counts[classTimeout], counts[classError])
+	for _, result := range results {
+		if result.Err != nil {
+			fmt.Printf("  %s [%s]: %v\n", result.Host, failureClass(result.Err), result.Err)
+		}
+	}
+
+	return len(results) - counts[classOK]
+}
+
+// printParseFailures lists the commands whose output a template couldn't parse.
+// The raw output is still saved, so these don't fail the device.
+func printParseFailures(records []*deviceRecord) {
 
+	for _, record := range records {
+		for _, result := range record.Commands {
+			if result.ParseError != "" {
+				fmt.Printf("%s: could not parse %q: %s\n", record.Hostname, result.Command, result.ParseError)
+			}
+		}
+	}
+}
+
+// connectFlags are the flags shared by every subcommand that connects to devices
+type connectFlags struct {
+	Settings     connectSettings
+	Credentials  credentialSpec
+	Workers      int
+	passwordFile string
+	netrcFile    string
+	vaultFile    string
+	retryOn      string
+}
+
+func (c *connectFlags) register(fs *flag.FlagSet) {
+
+	fs.IntVar(&c.Workers, "workers", 8, "number of devices to connect to concurrently")
+	limits := &c.Settings.Limits
+	fs.DurationVar(&limits.Connect, "connect-timeout", 15*time.Second, "timeout for opening the SSH session to a device")
+	fs.DurationVar(&limits.Command, "command-timeout", 60*time.Second, "timeout for each command sent to a device")
+	fs.DurationVar(&limits.Device, "device-timeout", 10*time.Minute, "overall time limit per device, 0 for none")
+	retry := &c.Settings.Retry
+	fs.IntVar(&retry.MaxAttempts, "retries", 3, "attempts per connection or command before giving up")
+	fs.DurationVar(&retry.BaseDelay, "retry-delay", 2*time.Second, "wait before the first retry, doubled on each further attempt")
+	fs.DurationVar(&retry.MaxDelay, "retry-max-delay", 30*time.Second, "longest wait between retries")
+	fs.Float64Var(&retry.Jitter, "retry-jitter", 0.2, "random fraction added to or taken from each retry wait")
+	fs.StringVar(&c.retryOn, "retry-on", "connection,timeout", "comma separated error classes to retry: connection, timeout, other")
+	fs.StringVar(&c.Settings.HostKeys.Mode, "host-key-check", hostKeyStrict, "host key checking: strict (known_hosts only), tofu (record new keys) or off")
+	fs.StringVar(&c.Settings.HostKeys.KnownHosts, "known-hosts", defaultKnownHostsFile(), "known_hosts file used to verify device host keys")
+	fs.StringVar(&c.Credentials.Source, "credentials", credentialsPrompt, "credential source: prompt, env, password_file, netrc, process or vault; inventory groups may override it")
+	fs.StringVar(&c.Credentials.Username, "username", "", "username for every device, prompted for when empty")
+	fs.StringVar(&c.passwordFile, "password-file", "", "file holding the password, for -credentials password_file")
+	fs.StringVar(&c.netrcFile, "netrc", "", "netrc file to look devices up in, for -credentials netrc (default ~/.netrc)")
+	fs.StringVar(&c.vaultFile, "vault", defaultVaultFile(), "encrypted credential vault, for -credentials vault")
+	fs.StringVar(&c.Credentials.Command, "credential-process", "", "command printing {\"username\":...,\"password\":...}, for -credentials process")
+	fs.StringVar(&c.Settings.Auth.KeyFile, "ssh-key", "", "private key file, a device's ssh_key in the inventory overrides it")
+	fs.StringVar(&c.Settings.Auth.Agent, "ssh-agent", "", "ssh-agent socket to authenticate with, e.g. \"$SSH_AUTH_SOCK\"")
+	fs.BoolVar(&c.Settings.Auth.PasswordFallback, "password-fallback", false, "also try a password when key or agent authentication fails")
+}
+
+// credentialDefaults is the run-wide credential spec, reading whichever file
+// flag belongs to its source
+func (c *connectFlags) credentialDefaults() credentialSpec {
+
+	spec := c.Credentials
+	spec.File = c.passwordFile
+	switch spec.Source {
+	case credentialsNetrc:
+		spec.File = c.netrcFile
+	case credentialsVault:
+		spec.File = c.vaultFile
+	}
+	return spec
+}
+
+// prepare checks the flags and looks up every device's credentials before any
+// worker starts, so prompts don't interleave with progress output. Only devices
+// that can't make do with a key or the agent need a password. A device whose
+// credentials can't be found gets an error rather than failing the run. The
+// caller removes Settings.JumpConfig once done.
+func (c *connectFlags) prepare(devices []Device) (map[string]credentials, map[string]error, error) {
+
+	var err error
+	c.Settings.Retry.RetryOn, err = parseRetryClasses(c.retryOn)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := c.Settings.HostKeys.validate(); err != nil {
+		return nil, nil, err
+	}
+
+	defaults := c.credentialDefaults()
+	if _, err := newCredentialProvider(defaults); err != nil {
+		return nil, nil, err
+	}
+	resolver := newCredentialResolver(defaults)
+	deviceCreds := map[string]credentials{}
+	credentialErrs := map[string]error{}
+	keyFiles := []string{}
+	auth := &c.Settings.Auth
+	auth.KeyFile = expandHome(auth.KeyFile)
 	for _, device := range devices {
+		creds, err := resolver.resolve(device, auth.usesPassword(device))
+		if err != nil {
+			credentialErrs[device.Hostname] = fmt.Errorf("credentials: %w", err)
+		}
+		deviceCreds[device.Hostname] = creds
+		if key := auth.keyFor(device); key != "" {
+			keyFiles = append(keyFiles, key)
+		}
+	}
+	if auth.Agent == "" {
+		auth.Passphrases = getPassphrases(keyFiles)
+	}
+
+	c.Settings.JumpConfig, err = writeJumpConfig(devices, c.Settings)
+	if err != nil {
+		return nil, nil, err
+	}
+	return deviceCreds, credentialErrs, nil
+}
 
-		_, err := connectAndRunCmds("juniper_junos", device, uname, pword, commands)
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "vault":
+			if err := runVault(os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, "Error:", err)
+				os.Exit(1)
+			}
+			return
+		case "push":
+			// Exit 1 when any device failed and 2 when the push couldn't start
+			failed, err := runPush(os.Args[2:])
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error:", err)
+				os.Exit(2)
+			}
+			if failed > 0 {
+				os.Exit(1)
+			}
+			return
+		case "diff":
+			// Exit like diff(1): 0 when nothing changed, 1 when something did or a
+			// device couldn't be compared, 2 on trouble
+			changed, err := runDiff(os.Args[2:], os.Stdout)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error:", err)
+				os.Exit(2)
+			}
+			if changed {
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	var connect connectFlags
+	connect.register(flag.CommandLine)
+	settings := &connect.Settings
+	limits := &settings.Limits
+	inventoryFile := flag.String("inventory", "inventory.yaml", "inventory file (.yaml, .csv, or one hostname per line)")
+	commandsDir := flag.String("commands-dir", "commands", "directory of command sets named by platform, group or role, e.g. junos.txt, junos-core.yaml")
+	commandsFile := flag.String("commands", "", "optional command file for devices no command set applies to")
+	outputDir := flag.String("output-dir", ".", "directory results are written under")
+	outputLayoutFlag := flag.String("layout", defaultLayout, "path of each result file under -output-dir, e.g. \"{{date}}/{{run_id}}/{{host}}/{{command_slug}}.{{ext}}\"; must include {{run_id}} or {{time}}")
+	format := flag.String("format", formatText, "comma separated output formats: text, json, yaml")
+	mode := flag.String("mode", modeCollect, "collect sends the command sets; backup pulls each Junos device's configuration as set, XML and JSON")
+	backupDir := flag.String("backup-dir", "backups", "directory holding each device's latest configuration backup, for -mode backup")
+	parse := flag.Bool("parse", false, "parse command output with TextFSM templates, stored in json and yaml results or in a .parsed.json file next to text ones")
+	textfsmDir := flag.String("textfsm-dir", "", "directory of extra TextFSM templates named <platform>_<command>.textfsm, overriding bundled ones")
+	facts := flag.Bool("facts", false, "also gather model, version, serial, interface and BGP facts from Junos devices as JSON")
+	archiveDir := flag.String("archive", "", "git repository to commit each device's latest outputs to, one directory per device")
+	operator := flag.String("operator", defaultOperator(), "name recorded in archive commit messages")
+	dryRun := flag.Bool("dry-run", false, "print each device's platform, transport, credential source, jump hosts and commands without connecting")
+	flag.BoolVar(&settings.StopOnError, "stop-on-error", false, "stop sending a device its remaining commands once it rejects one")
+	flag.Parse()
+
+	formats, err := parseOutputFormats(*format)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var parser *textfsmParser
+	if *parse {
+		parser, err = loadTextFSMTemplates(*textfsmDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	started := time.Now()
+	runID, err := newRunID(started)
+	if err != nil {
+		log.Fatal(err)
+	}
+	layout, err := newOutputLayout(*outputDir, *outputLayoutFlag, formats, started, runID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	inventory, err := loadInventory(*inventoryFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	var commandsFor commandSource
+	switch *mode {
+	case modeCollect:
+		sets, err := loadCommandSets(*commandsFile, *commandsDir)
 		if err != nil {
-			fmt.Println("Error: ", err)
+			log.Fatal(err)
+		}
+		commandsFor = sets.forDevice
+	case modeBackup:
+		commandsFor = backupCommandsFor
+	default:
+		log.Fatalf("unknown mode %q (expected %s or %s)", *mode, modeCollect, modeBackup)
+	}
+	if *facts {
+		commandsFor = withJunosFacts(commandsFor)
+	}
+
+	if *dryRun {
+		if failed := printDryRun(os.Stdout, inventory.Devices, commandsFor, &connect); failed > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	archive := gitArchive{Dir: *archiveDir, Operator: *operator}
+	if archive.Dir != "" {
+		if err := archive.open(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	deviceCreds, credentialErrs, err := connect.prepare(inventory.Devices)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Every device's record goes into the run manifest, keyed by hostname
+	var recordsMu sync.Mutex
+	records := map[string]*deviceRecord{}
+
+	collect := func(device Device) (string, error) {
+		if err := credentialErrs[device.Hostname]; err != nil {
+			return "", err
+		}
+		ctx, cancel := limits.deviceContext(context.Background())
+		defer cancel()
+		record, err := connectAndRunCmds(ctx, device, deviceCreds[device.Hostname], commandsFor, *settings)
+		if record == nil {
+			return "", err
+		}
+		if parser != nil {
+			parser.parseRecord(record)
+		}
+		if *facts {
+			gatherJunosFacts(record)
+		}
+		if *mode == modeBackup {
+			normaliseBackup(record)
+			if _, backupErr := writeBackup(*backupDir, record); backupErr != nil && err == nil {
+				err = fmt.Errorf("failed to write backup %w", backupErr)
+			}
+		}
+		record.RunID = layout.RunID
+		recordsMu.Lock()
+		records[device.Hostname] = record
+		recordsMu.Unlock()
+		if _, writeErr := layout.write(device, record, formats); writeErr != nil && err == nil {
+			err = writeErr
+		}
+		return record.text(), err
+	}
+
+	// Print one line per device as it finishes, from a single goroutine
+	report := func(result deviceResult) {
+		if result.Err != nil {
+			fmt.Printf("%s: Error: %v\n", result.Host, result.Err)
+		} else {
+			fmt.Printf("%s: done\n", result.Host)
 		}
 	}
+
+	fmt.Printf("Run %s\n", runID)
+	results := runWorkerPool(inventory.Devices, connect.Workers, collect, report)
+	if settings.JumpConfig != "" {
+		os.Remove(settings.JumpConfig)
+	}
+
+	manifest := &runManifest{RunID: runID, Started: started.UTC(), Finished: time.Now().UTC()}
+	for _, result := range results {
+		record := records[result.Host]
+		if record == nil {
+			record = &deviceRecord{RunID: runID, Hostname: result.Host, Commands: []commandResult{}}
+			record.finish(result.Err)
+		}
+		manifest.Devices = append(manifest.Devices, record)
+	}
+	if err := writeManifest(*outputDir, manifest); err != nil {
+		fmt.Fprintln(os.Stderr, "Error: failed to write the run manifest", err)
+	}
+
+	if archive.Dir != "" {
+		for _, record := range manifest.Devices {
+			if err := archive.update(record); err != nil {
+				fmt.Fprintln(os.Stderr, "Error: failed to update the archive", err)
+			}
+		}
+		changed, err := archive.commit(runID)
+		switch {
+		case err != nil:
+			fmt.Fprintln(os.Stderr, "Error: failed to commit to the archive", err)
+		case len(changed) == 0:
+			fmt.Printf("Archive %s: no changes\n", archive.Dir)
+		default:
+			fmt.Printf("Archive %s: committed changes to %s\n", archive.Dir, strings.Join(changed, ", "))
+		}
+	}
+	printParseFailures(manifest.Devices)
+	printRejectedCommands(manifest.Devices)
+	if failed := printSummary("Collected", results); failed > 0 {
+		os.Exit(1)
+	}
 }
@@ -1,122 +1,236 @@
 package main
 
 import (
-	"fmt"
-	"github.com/scrapli/scrapligo/driver/options"
-	"github.com/scrapli/scrapligo/platform"
-	"io/ioutil"
-	"log"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
 	"time"
-	"golang.org/x/term"
 )
 
-func connectAndRunCmds() (string, error) {
-
-	p, err := platform.NewPlatform("juniper_junos", "rr1.hex", options.WithAuthNoStrictKey(), options.WithAuthUsername("ssaeed"), options.WithAuthPassword("Suhaib.saeedgamma2022"))
-	if err != nil {
-		return "", fmt.Errorf("failed to create platform %+v", err)
+func TestFileToSlice(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "devices.txt")
+	if err := os.WriteFile(path, []byte("mx1\n  mx2  \n\nmx3"), 0644); err != nil {
+		t.Fatal(err)
 	}
 
-	d, err := p.GetNetworkDriver()
-	if err != nil {
-		return "", fmt.Errorf("failed to fetch network driver from the platform; error: %+v\n", err)
+	got := fileToSlice(path)
+	want := []string{"mx1", "mx2", "mx3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("fileToSlice() = %q, want %q", got, want)
 	}
+}
 
-	err = d.Open()
-	if err != nil {
-		return "", fmt.Errorf("failed to open driver %+v", err)
+func TestRunWorkerPoolKeepsOrderAndErrors(t *testing.T) {
+	devices := []Device{{Hostname: "mx1"}, {Hostname: "mx2"}, {Hostname: "mx3"}, {Hostname: "mx4"}}
+	collect := func(device Device) (string, error) {
+		if device.Hostname == "mx3" {
+			return "", errors.New("boom")
+		}
+		return "output " + device.Hostname, nil
 	}
 
-	defer d.Close()
+	reported := 0
+	results := runWorkerPool(devices, 2, collect, func(deviceResult) { reported++ })
 
-	cmd := "show version"
-	output, err := d.Channel.SendInput(cmd)
-	if err != nil {
-		return "", fmt.Errorf("failed to send input to device %+v", err)
+	if reported != len(devices) {
+		t.Errorf("report called %d times, want %d", reported, len(devices))
+	}
+	for i, result := range results {
+		if result.Host != devices[i].Hostname {
+			t.Errorf("results[%d].Host = %q, want %q", i, result.Host, devices[i].Hostname)
+		}
+		if (result.Err != nil) != (result.Host == "mx3") {
+			t.Errorf("results[%d].Err = %v", i, result.Err)
+		}
 	}
-
-	return string(output), nil
 }
 
-func fileToSlice(file string) []string {
-
-	// Use ReadFile function to get content of file
-	content, error := ioutil.ReadFile(file)
-
-	// Check for errors, if yes then print error and exit
-	if error != nil {
-		log.Fatal(error)
+func TestRunWorkerPoolBoundsConcurrency(t *testing.T) {
+	devices := make([]Device, 20)
+
+	var running, peak int32
+	collect := func(Device) (string, error) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return "", nil
 	}
 
-	// Convert file content into string and print
-	fileStr := string(content)
-	// Turn string into slice
-	original_slice := strings.Split(fileStr, "\n")
-
-	// Loop through each string in the list and remove whitespace and empty strings
-	file_list := []string{}
-	for _, str := range original_slice {
-		// Only add non-empty strings to new slice
-		if str != "" {
-			trimmedStr := strings.TrimSpace(str)
-			file_list = append(file_list, trimmedStr)
-		}
+	runWorkerPool(devices, 3, collect, nil)
 
+	if peak > 3 {
+		t.Errorf("peak concurrency = %d, want at most 3", peak)
 	}
-
-	return file_list
 }
 
-func getCurrentTime() string {
-
-	// Get current time
-	currentTime := time.Now()
-
-	formattedTime := currentTime.Format("02-01-06@15.04")
-
-	return formattedTime
+// fakeReply is one answer a fakeDevice gives to a command
+type fakeReply struct {
+	output string
+	err    error
 }
 
-func getCreds() (string, string) {
-
-	// Get username and password from the user
-	var username string
-	fmt.Println("Please enter your username: ")
-	fmt.Scan(&username)
-
-	fmt.Print("Enter Password: ")
-	// Read password from terminal without echoing it back
-	password, error := term.ReadPassword(0)
+// fakeDevice hands out sessions that answer from a script, each command
+// taking the next of its replies and echoing the command once they run out
+type fakeDevice struct {
+	mu       sync.Mutex
+	replies  map[string][]fakeReply
+	openErrs []error
+	opens    int
+	sent     []string
+}
 
-	if error != nil {
-		log.Fatal(error)
+func (f *fakeDevice) open(device Device, creds credentials, settings connectSettings) (deviceDriver, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.opens++
+	if len(f.openErrs) > 0 {
+		err := f.openErrs[0]
+		f.openErrs = f.openErrs[1:]
+		return nil, err
 	}
-	// Turn password from bytes into string
-	passwordStr := string(password)
-
-	return username, passwordStr
-
+	return fakeSession{f}, nil
 }
 
+type fakeSession struct {
+	device *fakeDevice
+}
 
-
-func main() {
-	// devices := fileToSlice("devices.txt")
-	// commands := fileToSlice("commands.txt")
-	// timeNow := getCurrentTime()
-	// uname, pword := getCreds()
-
-	// fmt.Println(devices)
-	// fmt.Println(commands)
-	// fmt.Println(timeNow)
-	// fmt.Println(uname, pword)
-
-	output, err := connectAndRunCmds()
-	if err != nil {
-		fmt.Println("Error: ", err)
-	} else {
-		fmt.Println("Output: ", output)
+func (s fakeSession) run(cmd string) (commandReply, error) {
+	f := s.device
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = append(f.sent, cmd)
+	if replies := f.replies[cmd]; len(replies) > 0 {
+		f.replies[cmd] = replies[1:]
+		return commandReply{Output: replies[0].output}, replies[0].err
 	}
+	return commandReply{Output: "output of " + cmd}, nil
+}
 
+func (s fakeSession) close() {}
+
+func (s fakeSession) abort() {}
+
+func TestConnectAndRunCmds(t *testing.T) {
+	commands := []string{"show version", "show intefaces", "show route"}
+	rejected := fakeReply{"syntax error, expecting <command>.", &cliError{Class: cliErrorSyntax, Line: "syntax error, expecting <command>."}}
+	lost := fakeReply{"", io.EOF}
+	noRetry := retryPolicy{MaxAttempts: 1}
+	retryConnection := retryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, RetryOn: []string{errorClassConnection}}
+
+	cases := []struct {
+		name        string
+		replies     map[string][]fakeReply
+		openErrs    []error
+		retry       retryPolicy
+		stopOnError bool
+		// sent is every command the device saw, commands how many the record holds
+		sent     []string
+		commands int
+		opens    int
+		err      string
+		// rejected is whether the record marks show intefaces as rejected
+		rejected bool
+	}{
+		{
+			name:     "all good",
+			retry:    noRetry,
+			sent:     commands,
+			commands: 3,
+			opens:    1,
+		},
+		{
+			name:     "carries on past a rejected command",
+			replies:  map[string][]fakeReply{"show intefaces": {rejected}},
+			retry:    retryConnection,
+			sent:     commands,
+			commands: 3,
+			opens:    1,
+			err:      "1 of 3 commands failed",
+			rejected: true,
+		},
+		{
+			name:        "stops on a rejected command with -stop-on-error",
+			replies:     map[string][]fakeReply{"show intefaces": {rejected}},
+			retry:       noRetry,
+			stopOnError: true,
+			sent:        commands[:2],
+			commands:    2,
+			opens:       1,
+			err:         "stopped after command 2 of 3",
+			rejected:    true,
+		},
+		{
+			name:     "stops on a lost session",
+			replies:  map[string][]fakeReply{"show intefaces": {lost}},
+			retry:    noRetry,
+			sent:     commands[:2],
+			commands: 2,
+			opens:    1,
+			err:      "failed to send input to device EOF",
+		},
+		{
+			name:     "reopens the session to retry a failed command",
+			replies:  map[string][]fakeReply{"show intefaces": {lost}},
+			retry:    retryConnection,
+			sent:     []string{"show version", "show intefaces", "show intefaces", "show route"},
+			commands: 3,
+			opens:    2,
+		},
+		{
+			name:     "open fails",
+			openErrs: []error{errors.New("failed to open driver: connection refused")},
+			retry:    noRetry,
+			opens:    1,
+			err:      "connection refused",
+		},
+	}
+	for _, c := range cases {
+		device := &fakeDevice{replies: c.replies, openErrs: c.openErrs}
+		settings := connectSettings{Retry: c.retry, StopOnError: c.stopOnError, Open: device.open}
+		commandsFor := func(Device) ([]string, error) { return commands, nil }
+
+		record, err := connectAndRunCmds(context.Background(), Device{Hostname: "mx1", Platform: "juniper_junos"}, credentials{}, commandsFor, settings)
+		switch {
+		case c.err == "" && err != nil:
+			t.Errorf("%s: %v", c.name, err)
+		case c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)):
+			t.Errorf("%s: err = %v, want %q", c.name, err, c.err)
+		}
+		if !reflect.DeepEqual(device.sent, c.sent) {
+			t.Errorf("%s: sent %q, want %q", c.name, device.sent, c.sent)
+		}
+		if device.opens != c.opens {
+			t.Errorf("%s: opened %d sessions, want %d", c.name, device.opens, c.opens)
+		}
+		if c.commands == 0 {
+			if record != nil {
+				t.Errorf("%s: record = %+v, want nil", c.name, record)
+			}
+			continue
+		}
+		if record == nil || len(record.Commands) != c.commands {
+			t.Errorf("%s: record = %+v, want %d commands", c.name, record, c.commands)
+			continue
+		}
+		if (c.err == "") != (record.Error == "") {
+			t.Errorf("%s: record error = %q", c.name, record.Error)
+		}
+		if second := record.Commands[1]; second.Rejected != c.rejected || (c.rejected && second.ErrorClass != cliErrorSyntax) {
+			t.Errorf("%s: show intefaces = %+v", c.name, second)
+		}
+	}
 }
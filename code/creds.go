@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// Credential sources, chosen with -credentials or per inventory group
+const (
+	credentialsPrompt       = "prompt"
+	credentialsEnv          = "env"
+	credentialsPasswordFile = "password_file"
+	credentialsNetrc        = "netrc"
+	credentialsProcess      = "process"
+	credentialsVault        = "vault"
+)
+
+// credentialProcessTimeout bounds a credential_process helper
+const credentialProcessTimeout = 30 * time.Second
+
+// credentials is the username and password used for one device
+type credentials struct {
+	Username string
+	Password string
+}
+
+// credentialSpec selects and configures a credential source. It is set from
+// the command line flags and may be overridden by an inventory group:
+//
+//	credentials:
+//	  source: process
+//	  command: vault-helper --role core
+type credentialSpec struct {
+	Source string `yaml:"source"`
+	// Username overrides whatever username the source would use
+	Username string `yaml:"username"`
+	// UsernameEnv and PasswordEnv name the variables read by the env source
+	UsernameEnv string `yaml:"username_env"`
+	PasswordEnv string `yaml:"password_env"`
+	// File is read by the password_file, netrc and vault sources
+	File string `yaml:"file"`
+	// Command is run by the process source
+	Command string `yaml:"command"`
+}
+
+// credentialProvider looks up the credentials for a device. needPassword is
+// false for devices authenticating with a key or agent only.
+type credentialProvider interface {
+	credentials(device Device, needPassword bool) (credentials, error)
+}
+
+// describeCredentials says where a spec's credentials come from without
+// looking them up. A process source only shows the program, see
+// credentialProgram.
+func describeCredentials(spec credentialSpec) string {
+
+	description := spec.Source
+	switch spec.Source {
+	case credentialsPrompt, "":
+		description = credentialsPrompt
+	case credentialsEnv:
+		usernameEnv, passwordEnv := spec.UsernameEnv, spec.PasswordEnv
+		if usernameEnv == "" {
+			usernameEnv = "COLLECTOR_USERNAME"
+		}
+		if passwordEnv == "" {
+			passwordEnv = "COLLECTOR_PASSWORD"
+		}
+		description = fmt.Sprintf("%s (%s, %s)", credentialsEnv, usernameEnv, passwordEnv)
+	case credentialsPasswordFile:
+		description = fmt.Sprintf("%s (%s)", credentialsPasswordFile, spec.File)
+	case credentialsNetrc:
+		file := spec.File
+		if file == "" {
+			file = expandHome("~/.netrc")
+		}
+		description = fmt.Sprintf("%s (%s)", credentialsNetrc, file)
+	case credentialsProcess:
+		description = fmt.Sprintf("%s (%s)", credentialsProcess, credentialProgram(spec.Command))
+	case credentialsVault:
+		file := spec.File
+		if file == "" {
+			file = defaultVaultFile()
+		}
+		description = fmt.Sprintf("%s (%s)", credentialsVault, file)
+	}
+	if spec.Username != "" {
+		description += ", username " + spec.Username
+	}
+	return description
+}
+
+// credentialProgram names the program a process source runs, leaving out its
+// arguments since they may carry secrets
+func credentialProgram(command string) string {
+	if fields := strings.Fields(command); len(fields) > 0 {
+		return fields[0] + " ..."
+	}
+	return "<redacted>"
+}
+
+// newCredentialProvider builds the provider for a spec, checking it is complete
+func newCredentialProvider(spec credentialSpec) (credentialProvider, error) {
+
+	switch spec.Source {
+	case credentialsPrompt, "":
+		return &promptProvider{username: spec.Username}, nil
+	case credentialsEnv:
+		if spec.UsernameEnv == "" {
+			spec.UsernameEnv = "COLLECTOR_USERNAME"
+		}
+		if spec.PasswordEnv == "" {
+			spec.PasswordEnv = "COLLECTOR_PASSWORD"
+		}
+		return envProvider{spec}, nil
+	case credentialsPasswordFile:
+		if spec.File == "" {
+			return nil, fmt.Errorf("credential source %s needs a file", spec.Source)
+		}
+		return passwordFileProvider{spec}, nil
+	case credentialsNetrc:
+		if spec.File == "" {
+			spec.File = expandHome("~/.netrc")
+		}
+		return netrcProvider{spec}, nil
+	case credentialsProcess:
+		if spec.Command == "" {
+			return nil, fmt.Errorf("credential source %s needs a command", spec.Source)
+		}
+		return processProvider{spec}, nil
+	case credentialsVault:
+		if spec.File == "" {
+			spec.File = defaultVaultFile()
+		}
+		return &vaultProvider{spec: spec}, nil
+	}
+	return nil, fmt.Errorf("unknown credential source %q (expected %s, %s, %s, %s, %s or %s)", spec.Source,
+		credentialsPrompt, credentialsEnv, credentialsPasswordFile, credentialsNetrc, credentialsProcess, credentialsVault)
+}
+
+// promptProvider asks on the terminal, once per run, as the collector always has
+type promptProvider struct {
+	username string
+
+	mu       sync.Mutex
+	asked    bool
+	password bool
+	creds    credentials
+}
+
+func (p *promptProvider) credentials(device Device, needPassword bool) (credentials, error) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Ask again only if a later device needs the password the first one didn't
+	if p.asked && (p.password || !needPassword) {
+		return p.creds, nil
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return credentials{}, fmt.Errorf("cannot prompt for credentials, stdin is not a terminal; use -credentials %s, %s, %s, %s or %s",
+			credentialsEnv, credentialsPasswordFile, credentialsNetrc, credentialsProcess, credentialsVault)
+	}
+
+	username := p.username
+	if p.asked {
+		username = p.creds.Username
+	}
+	username, password := getCreds(username, needPassword)
+	p.asked, p.password = true, needPassword
+	p.creds = credentials{Username: username, Password: password}
+	return p.creds, nil
+}
+
+// envProvider reads the username and password from environment variables
+type envProvider struct {
+	spec credentialSpec
+}
+
+func (p envProvider) credentials(device Device, needPassword bool) (credentials, error) {
+
+	creds := credentials{
+		Username: os.Getenv(p.spec.UsernameEnv),
+		Password: os.Getenv(p.spec.PasswordEnv),
+	}
+	if p.spec.Username != "" {
+		creds.Username = p.spec.Username
+	}
+	if creds.Username == "" {
+		return credentials{}, fmt.Errorf("%s is not set", p.spec.UsernameEnv)
+	}
+	if needPassword && creds.Password == "" {
+		return credentials{}, fmt.Errorf("%s is not set", p.spec.PasswordEnv)
+	}
+	return creds, nil
+}
+
+// passwordFileProvider reads the password from the first line of a file,
+// which must not be readable by other users
+type passwordFileProvider struct {
+	spec credentialSpec
+}
+
+func (p passwordFileProvider) credentials(device Device, needPassword bool) (credentials, error) {
+
+	creds := credentials{Username: p.spec.Username}
+	if creds.Username == "" {
+		return credentials{}, fmt.Errorf("credential source %s needs a username", credentialsPasswordFile)
+	}
+	if !needPassword {
+		return creds, nil
+	}
+
+	password, err := readSecretFile(p.spec.File)
+	if err != nil {
+		return credentials{}, err
+	}
+	creds.Password = strings.SplitN(password, "\n", 2)[0]
+	return creds, nil
+}
+
+// readSecretFile reads a file holding a secret, refusing one that group or
+// other users can read, the same way ssh treats private keys
+func readSecretFile(path string) (string, error) {
+
+	path = expandHome(path)
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return "", fmt.Errorf("%s: permissions %v are too open, it must only be readable by its owner", path, info.Mode().Perm())
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// netrcProvider looks the device up in a netrc file by hostname, falling back
+// to the file's default entry
+type netrcProvider struct {
+	spec credentialSpec
+}
+
+func (p netrcProvider) credentials(device Device, needPassword bool) (credentials, error) {
+
+	content, err := readSecretFile(p.spec.File)
+	if err != nil {
+		return credentials{}, err
+	}
+	login, password, ok := parseNetrc(content, device.Hostname)
+	if !ok {
+		return credentials{}, fmt.Errorf("%s has no machine entry for %s and no default", p.spec.File, device.Hostname)
+	}
+
+	creds := credentials{Username: login, Password: password}
+	if p.spec.Username != "" {
+		creds.Username = p.spec.Username
+	}
+	if needPassword && creds.Password == "" {
+		return credentials{}, fmt.Errorf("%s has no password for %s", p.spec.File, device.Hostname)
+	}
+	return creds, nil
+}
+
+// parseNetrc finds the login and password for host in netrc content
+func parseNetrc(content string, host string) (string, string, bool) {
+
+	type entry struct{ login, password string }
+	var matched, fallback *entry
+	var current *entry
+
+	fields := strings.Fields(content)
+	for i := 0; i < len(fields); i++ {
+		switch fields[i] {
+		case "machine":
+			current = &entry{}
+			if i+1 < len(fields) && fields[i+1] == host && matched == nil {
+				matched = current
+			}
+			i++
+		case "default":
+			current = &entry{}
+			if fallback == nil {
+				fallback = current
+			}
+		case "login", "password", "account":
+			if current != nil && i+1 < len(fields) {
+				if fields[i] == "login" {
+					current.login = fields[i+1]
+				} else if fields[i] == "password" {
+					current.password = fields[i+1]
+				}
+			}
+			i++
+		case "macdef":
+			// Macros run to the next blank line and can't hold credentials, stop here
+			i = len(fields)
+		}
+	}
+
+	if matched == nil {
+		matched = fallback
+	}
+	if matched == nil {
+		return "", "", false
+	}
+	return matched.login, matched.password, true
+}
+
+// processProvider runs an external helper, in the style of AWS's
+// credential_process, which prints {"username": "...", "password": "..."}.
+// The device is passed in COLLECTOR_HOST, COLLECTOR_PLATFORM and COLLECTOR_GROUPS.
+type processProvider struct {
+	spec credentialSpec
+}
+
+func (p processProvider) credentials(device Device, needPassword bool) (credentials, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), credentialProcessTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", p.spec.Command)
+	cmd.Env = append(os.Environ(),
+		"COLLECTOR_HOST="+device.Hostname,
+		"COLLECTOR_PLATFORM="+device.Platform,
+		"COLLECTOR_GROUPS="+strings.Join(device.Groups, ","),
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return credentials{}, fmt.Errorf("credential process %s: %v: %s", credentialProgram(p.spec.Command), err, strings.TrimSpace(stderr.String()))
+	}
+
+	var reply struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.Unmarshal(output, &reply); err != nil {
+		// Don't echo the output, it may well contain the secret
+		return credentials{}, fmt.Errorf("credential process %s: output is not the expected JSON object", credentialProgram(p.spec.Command))
+	}
+
+	creds := credentials{Username: reply.Username, Password: reply.Password}
+	if p.spec.Username != "" {
+		creds.Username = p.spec.Username
+	}
+	if creds.Username == "" || (needPassword && creds.Password == "") {
+		return credentials{}, fmt.Errorf("credential process %s did not return a username and password", credentialProgram(p.spec.Command))
+	}
+	return creds, nil
+}
+
+// vaultProvider looks devices up in the encrypted vault, unlocking it once
+type vaultProvider struct {
+	spec credentialSpec
+
+	once  sync.Once
+	vault *vault
+	err   error
+}
+
+func (p *vaultProvider) credentials(device Device, needPassword bool) (credentials, error) {
+
+	p.once.Do(func() {
+		if _, err := os.Stat(p.spec.File); err != nil {
+			p.err = err
+			return
+		}
+		passphrase, err := getVaultPassphrase(fmt.Sprintf("Passphrase for %s: ", p.spec.File), true, false)
+		if err != nil {
+			p.err = err
+			return
+		}
+		p.vault, p.err = openVault(p.spec.File, passphrase)
+	})
+	if p.err != nil {
+		return credentials{}, p.err
+	}
+
+	entry, _, ok := p.vault.lookup(device)
+	if !ok {
+		return credentials{}, fmt.Errorf("%s has no entry for %s, its groups or a default", p.spec.File, device.Hostname)
+	}
+	creds := credentials{Username: entry.Username, Password: entry.Password}
+	if p.spec.Username != "" {
+		creds.Username = p.spec.Username
+	}
+	return creds, nil
+}
+
+// credentialResolver picks each device's provider: its first group with a
+// credentials block, otherwise the run-wide default
+type credentialResolver struct {
+	defaults  credentialSpec
+	providers map[credentialSpec]credentialProvider
+}
+
+func newCredentialResolver(defaults credentialSpec) *credentialResolver {
+	return &credentialResolver{defaults: defaults, providers: map[credentialSpec]credentialProvider{}}
+}
+
+// specFor returns the credential spec that applies to a device
+func (r *credentialResolver) specFor(device Device) credentialSpec {
+	if device.Credentials != nil {
+		spec := *device.Credentials
+		// Sources that don't supply a username of their own use the run-wide one
+		switch spec.Source {
+		case credentialsPrompt, credentialsPasswordFile, "":
+			if spec.Username == "" {
+				spec.Username = r.defaults.Username
+			}
+		}
+		return spec
+	}
+	return r.defaults
+}
+
+// resolve looks up the credentials for a device, reusing providers so a
+// prompt is only shown once
+func (r *credentialResolver) resolve(device Device, needPassword bool) (credentials, error) {
+
+	spec := r.specFor(device)
+	provider, ok := r.providers[spec]
+	if !ok {
+		var err error
+		provider, err = newCredentialProvider(spec)
+		if err != nil {
+			return credentials{}, err
+		}
+		r.providers[spec] = provider
+	}
+	return provider.credentials(device, needPassword)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEnvProvider(t *testing.T) {
+	t.Setenv("COLLECTOR_USERNAME", "netops")
+	t.Setenv("COLLECTOR_PASSWORD", "s3cret")
+
+	provider, err := newCredentialProvider(credentialSpec{Source: credentialsEnv})
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, err := provider.credentials(Device{Hostname: "mx1"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.Username != "netops" || creds.Password != "s3cret" {
+		t.Errorf("credentials = %+v", creds)
+	}
+
+	t.Setenv("COLLECTOR_PASSWORD", "")
+	if _, err := provider.credentials(Device{Hostname: "mx1"}, true); err == nil {
+		t.Error("expected an error for an unset password variable")
+	}
+	if _, err := provider.credentials(Device{Hostname: "mx1"}, false); err != nil {
+		t.Errorf("key-only device needs no password, got %v", err)
+	}
+}
+
+func TestPasswordFileProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(path, []byte("s3cret\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	provider, err := newCredentialProvider(credentialSpec{Source: credentialsPasswordFile, File: path, Username: "netops"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.credentials(Device{}, true); err == nil || !strings.Contains(err.Error(), "too open") {
+		t.Errorf("err = %v, want permissions error", err)
+	}
+
+	if err := os.Chmod(path, 0600); err != nil {
+		t.Fatal(err)
+	}
+	creds, err := provider.credentials(Device{}, true)
+	if err != nil || creds.Password != "s3cret" {
+		t.Errorf("credentials = %+v, %v", creds, err)
+	}
+}
+
+func TestParseNetrc(t *testing.T) {
+	content := `
+machine mx1 login alice password one
+machine mx2
+  login bob
+  password two
+default login ops password fallback
+`
+	cases := map[string][2]string{
+		"mx1": {"alice", "one"},
+		"mx2": {"bob", "two"},
+		"mx3": {"ops", "fallback"},
+	}
+	for host, want := range cases {
+		login, password, ok := parseNetrc(content, host)
+		if !ok || login != want[0] || password != want[1] {
+			t.Errorf("parseNetrc(%s) = %q, %q, %v, want %q", host, login, password, ok, want)
+		}
+	}
+
+	if _, _, ok := parseNetrc("machine mx1 login a password b", "mx2"); ok {
+		t.Error("matched a host with no entry and no default")
+	}
+}
+
+func TestProcessProvider(t *testing.T) {
+	provider, err := newCredentialProvider(credentialSpec{
+		Source:  credentialsProcess,
+		Command: `printf '{"username": "svc-%s", "password": "p"}' "$COLLECTOR_HOST"`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, err := provider.credentials(Device{Hostname: "mx1"}, true)
+	if err != nil || creds.Username != "svc-mx1" || creds.Password != "p" {
+		t.Errorf("credentials = %+v, %v", creds, err)
+	}
+
+	provider, _ = newCredentialProvider(credentialSpec{Source: credentialsProcess, Command: "echo not json"})
+	if _, err := provider.credentials(Device{Hostname: "mx1"}, true); err == nil {
+		t.Error("expected an error for non-JSON output")
+	}
+
+	// The error ends up in the run's results, so it names the program alone
+	provider, _ = newCredentialProvider(credentialSpec{Source: credentialsProcess, Command: "false --token s3cret"})
+	_, err = provider.credentials(Device{Hostname: "mx1"}, true)
+	if err == nil || strings.Contains(err.Error(), "s3cret") || !strings.Contains(err.Error(), "false ...") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestCredentialResolverUsesGroupSpec(t *testing.T) {
+	t.Setenv("CORE_USER", "core-ops")
+	t.Setenv("CORE_PASS", "x")
+
+	resolver := newCredentialResolver(credentialSpec{Source: credentialsPasswordFile, Username: "netops", File: "/nonexistent"})
+	device := Device{Hostname: "mx1", Credentials: &credentialSpec{Source: credentialsEnv, UsernameEnv: "CORE_USER", PasswordEnv: "CORE_PASS"}}
+
+	creds, err := resolver.resolve(device, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.Username != "core-ops" || creds.Password != "x" {
+		t.Errorf("credentials = %+v", creds)
+	}
+
+	device.Credentials = &credentialSpec{Source: credentialsPasswordFile, File: "/nonexistent"}
+	if spec := resolver.specFor(device); spec.Username != "netops" {
+		t.Errorf("password_file group spec username = %q, want the run-wide netops", spec.Username)
+	}
+}
@@ -0,0 +1,502 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// defaultIgnorePatterns match lines that change on every capture without the
+// device itself changing
+var defaultIgnorePatterns = []string{
+	`^## Last (commit|changed):.*`,
+	`(?i)\b(system )?uptime\b.*`,
+	`(?i)\blast (flapped|clearing|input|output)\b.*`,
+	`(?i)\btime since last\b.*`,
+}
+
+// diffOp is one line of an edit script: ' ' kept, '-' removed, '+' added
+type diffOp struct {
+	Kind byte
+	Line string
+}
+
+// diffLines returns the shortest edit script turning a into b, comparing
+// lines by key so ignored text doesn't count as a change. Each line's key is
+// worked out once and swapped for a number, then the lines go through Myers'
+// O(ND) algorithm in its linear space form, so two long captures that differ
+// all the way through, such as interface counters, cost time but not memory.
+func diffLines(a, b []string, key func(string) string) []diffOp {
+
+	ids := map[string]int{}
+	intern := func(lines []string) []int {
+		keys := make([]int, len(lines))
+		for i, line := range lines {
+			k := key(line)
+			id, ok := ids[k]
+			if !ok {
+				id = len(ids)
+				ids[k] = id
+			}
+			keys[i] = id
+		}
+		return keys
+	}
+
+	// Furthest reaching paths can't go past half the edits from either end
+	limit := (len(a)+len(b)+1)/2 + 1
+	m := &myers{
+		a: a, b: b,
+		ka: intern(a), kb: intern(b),
+		forward:  make([]int, 2*limit+3),
+		backward: make([]int, 2*limit+3),
+		offset:   limit + 1,
+		ops:      []diffOp{},
+	}
+	m.compare(0, len(a), 0, len(b))
+	return m.ops
+}
+
+// myers holds one diff's lines, their keys and the furthest reaching x on each
+// diagonal in both directions, reused by every middle snake search
+type myers struct {
+	a, b              []string
+	ka, kb            []int
+	forward, backward []int
+	offset            int
+	ops               []diffOp
+}
+
+// compare appends the edit script turning a[aLo:aHi] into b[bLo:bHi], splitting
+// it at the middle snake until what is left is all added or all removed
+func (m *myers) compare(aLo, aHi, bLo, bHi int) {
+
+	// Matching lines at either end are kept as they are
+	for aLo < aHi && bLo < bHi && m.ka[aLo] == m.kb[bLo] {
+		m.ops = append(m.ops, diffOp{' ', m.b[bLo]})
+		aLo++
+		bLo++
+	}
+	tail := 0
+	for aLo < aHi-tail && bLo < bHi-tail && m.ka[aHi-1-tail] == m.kb[bHi-1-tail] {
+		tail++
+	}
+	aHi, bHi = aHi-tail, bHi-tail
+
+	switch {
+	case aLo == aHi:
+		for _, line := range m.b[bLo:bHi] {
+			m.ops = append(m.ops, diffOp{'+', line})
+		}
+	case bLo == bHi:
+		for _, line := range m.a[aLo:aHi] {
+			m.ops = append(m.ops, diffOp{'-', line})
+		}
+	default:
+		// With the ends stripped there are at least two edits, so both halves
+		// either side of the snake are smaller problems
+		x, y, u, v := m.middleSnake(aLo, aHi, bLo, bHi)
+		m.compare(aLo, x, bLo, y)
+		for _, line := range m.b[y:v] {
+			m.ops = append(m.ops, diffOp{' ', line})
+		}
+		m.compare(u, aHi, v, bHi)
+	}
+
+	for _, line := range m.b[bHi : bHi+tail] {
+		m.ops = append(m.ops, diffOp{' ', line})
+	}
+}
+
+// middleSnake searches from both corners at once until the paths meet, and
+// returns the run of matching lines (x, y) to (u, v) the shortest edit script
+// passes through halfway along
+func (m *myers) middleSnake(aLo, aHi, bLo, bHi int) (int, int, int, int) {
+
+	n, mm := aHi-aLo, bHi-bLo
+	delta := n - mm
+	odd := delta%2 != 0
+	f, r, o := m.forward, m.backward, m.offset
+	f[o+1], r[o+1] = 0, 0
+
+	for d := 0; d <= (n+mm+1)/2; d++ {
+		// Forward from the top left, x and y counted from aLo and bLo
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && f[o+k-1] < f[o+k+1]) {
+				x = f[o+k+1]
+			} else {
+				x = f[o+k-1] + 1
+			}
+			y := x - k
+			x0, y0 := x, y
+			for x < n && y < mm && m.ka[aLo+x] == m.kb[bLo+y] {
+				x++
+				y++
+			}
+			f[o+k] = x
+			if c := delta - k; odd && c >= -(d-1) && c <= d-1 && x+r[o+c] >= n {
+				return aLo + x0, bLo + y0, aLo + x, bLo + y
+			}
+		}
+		// Backward from the bottom right, x and y counted back from aHi and bHi
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && r[o+k-1] < r[o+k+1]) {
+				x = r[o+k+1]
+			} else {
+				x = r[o+k-1] + 1
+			}
+			y := x - k
+			x0, y0 := x, y
+			for x < n && y < mm && m.ka[aHi-1-x] == m.kb[bHi-1-y] {
+				x++
+				y++
+			}
+			r[o+k] = x
+			if c := delta - k; !odd && c >= -d && c <= d && x+f[o+c] >= n {
+				return aHi - x, bHi - y, aHi - x0, bHi - y0
+			}
+		}
+	}
+	panic("middleSnake: the forward and backward paths never met")
+}
+
+// unifiedDiff renders an edit script as unified diff hunks with the given
+// lines of context, or "" when nothing changed
+func unifiedDiff(oldName, newName string, ops []diffOp, context int) string {
+
+	// Find the changed ops, then group them into hunks whose context overlaps
+	changed := []int{}
+	for i, op := range ops {
+		if op.Kind != ' ' {
+			changed = append(changed, i)
+		}
+	}
+	if len(changed) == 0 {
+		return ""
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", oldName, newName)
+
+	for i := 0; i < len(changed); {
+		start := changed[i] - context
+		if start < 0 {
+			start = 0
+		}
+		end := changed[i]
+		for i < len(changed) && changed[i]-context <= end+context {
+			end = changed[i]
+			i++
+		}
+		end += context + 1
+		if end > len(ops) {
+			end = len(ops)
+		}
+
+		// Line numbers are 1-based counts of the lines before the hunk on each side
+		oldStart, newStart := 1, 1
+		for _, op := range ops[:start] {
+			if op.Kind != '+' {
+				oldStart++
+			}
+			if op.Kind != '-' {
+				newStart++
+			}
+		}
+		oldCount, newCount := 0, 0
+		for _, op := range ops[start:end] {
+			if op.Kind != '+' {
+				oldCount++
+			}
+			if op.Kind != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(oldStart, oldCount), hunkRange(newStart, newCount))
+		for _, op := range ops[start:end] {
+			fmt.Fprintf(&out, "%c%s\n", op.Kind, op.Line)
+		}
+	}
+	return out.String()
+}
+
+// hunkRange formats one side of a hunk header the way diff -u does
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start-1)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// lineMasker blanks out the parts of a line matched by any ignore pattern
+type lineMasker []*regexp.Regexp
+
+func (m lineMasker) key(line string) string {
+	for _, pattern := range m {
+		line = pattern.ReplaceAllString(line, "")
+	}
+	return strings.TrimRight(line, " \t\r")
+}
+
+// snapshotDiff is the outcome of comparing two runs
+type snapshotDiff struct {
+	// Changed maps hostname to the commands whose output differs
+	Changed   map[string][]string
+	Unchanged []string
+	OnlyOld   []string
+	OnlyNew   []string
+	// Incomplete lists devices that failed or lost commands in either run, so
+	// they can't be called unchanged. Skipped says what was missing.
+	Incomplete []string
+	// Skipped notes commands that couldn't be compared, e.g. failed in one run
+	Skipped []string
+}
+
+// compareRuns writes unified diffs for every device and command in either run
+// to out and returns a summary of what changed
+func compareRuns(out io.Writer, old, new *runManifest, masker lineMasker, context int, hosts map[string]bool) *snapshotDiff {
+
+	result := &snapshotDiff{Changed: map[string][]string{}}
+
+	names := map[string]bool{}
+	for _, manifest := range []*runManifest{old, new} {
+		for _, record := range manifest.Devices {
+			if len(hosts) == 0 || hosts[record.Hostname] {
+				names[record.Hostname] = true
+			}
+		}
+	}
+	hostnames := make([]string, 0, len(names))
+	for name := range names {
+		hostnames = append(hostnames, name)
+	}
+	sort.Strings(hostnames)
+
+	for _, host := range hostnames {
+		before, after := old.device(host), new.device(host)
+		switch {
+		case after == nil:
+			result.OnlyOld = append(result.OnlyOld, host)
+			continue
+		case before == nil:
+			result.OnlyNew = append(result.OnlyNew, host)
+			continue
+		}
+
+		incomplete := false
+		for _, side := range []struct {
+			record *deviceRecord
+			runID  string
+		}{{before, old.RunID}, {after, new.RunID}} {
+			if side.record.Error != "" {
+				result.Skipped = append(result.Skipped, fmt.Sprintf("%s: failed in %s: %s", host, side.runID, side.record.Error))
+				incomplete = true
+			}
+		}
+
+		commands := []string{}
+		seen := map[string]bool{}
+		for _, record := range []*deviceRecord{before, after} {
+			for _, cmd := range record.Commands {
+				if !seen[cmd.Command] {
+					commands = append(commands, cmd.Command)
+					seen[cmd.Command] = true
+				}
+			}
+		}
+
+		for _, command := range commands {
+			oldCmd, newCmd := before.command(command), after.command(command)
+			skipped := ""
+			switch {
+			case oldCmd == nil:
+				skipped = fmt.Sprintf("%s: %q was not run in %s", host, command, old.RunID)
+			case newCmd == nil:
+				skipped = fmt.Sprintf("%s: %q was not run in %s", host, command, new.RunID)
+			case oldCmd.Error != "":
+				skipped = fmt.Sprintf("%s: %q failed in %s", host, command, old.RunID)
+			case newCmd.Error != "":
+				skipped = fmt.Sprintf("%s: %q failed in %s", host, command, new.RunID)
+			}
+			if skipped != "" {
+				result.Skipped = append(result.Skipped, skipped)
+				incomplete = true
+				continue
+			}
+
+			ops := diffLines(splitLines(oldCmd.Output), splitLines(newCmd.Output), masker.key)
+			label := host + ": " + command
+			diff := unifiedDiff(old.RunID+" "+label, new.RunID+" "+label, ops, context)
+			if diff != "" {
+				fmt.Fprint(out, diff)
+				result.Changed[host] = append(result.Changed[host], command)
+			}
+		}
+		switch {
+		case incomplete:
+			result.Incomplete = append(result.Incomplete, host)
+		case len(result.Changed[host]) == 0:
+			result.Unchanged = append(result.Unchanged, host)
+		}
+	}
+	return result
+}
+
+func splitLines(output string) []string {
+	output = strings.TrimRight(strings.ReplaceAll(output, "\r\n", "\n"), "\n")
+	if output == "" {
+		return nil
+	}
+	return strings.Split(output, "\n")
+}
+
+// command returns the result for a command, or nil if the record doesn't have one
+func (r *deviceRecord) command(command string) *commandResult {
+	for i := range r.Commands {
+		if r.Commands[i].Command == command {
+			return &r.Commands[i]
+		}
+	}
+	return nil
+}
+
+// printDiffSummary lists which devices and commands changed
+func printDiffSummary(out io.Writer, result *snapshotDiff) {
+
+	hosts := make([]string, 0, len(result.Changed))
+	for host := range result.Changed {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	fmt.Fprintf(out, "\n%d device(s) changed, %d unchanged, %d incomplete\n", len(hosts), len(result.Unchanged), len(result.Incomplete))
+	for _, host := range hosts {
+		fmt.Fprintf(out, "  %s: %s\n", host, strings.Join(result.Changed[host], ", "))
+	}
+	if len(result.Incomplete) > 0 {
+		fmt.Fprintf(out, "Failed or missing commands in either run: %s\n", strings.Join(result.Incomplete, ", "))
+	}
+	if len(result.OnlyOld) > 0 {
+		fmt.Fprintf(out, "Only in the old run: %s\n", strings.Join(result.OnlyOld, ", "))
+	}
+	if len(result.OnlyNew) > 0 {
+		fmt.Fprintf(out, "Only in the new run: %s\n", strings.Join(result.OnlyNew, ", "))
+	}
+	for _, skipped := range result.Skipped {
+		fmt.Fprintf(out, "Not compared: %s\n", skipped)
+	}
+}
+
+// stringList is a flag that may be given more than once
+type stringList []string
+
+func (s *stringList) String() string { return strings.Join(*s, ",") }
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// loadIgnorePatterns compiles the ignore patterns, one per -ignore flag and
+// one per non-comment line of each ignore file
+func loadIgnorePatterns(patterns []string, files []string, defaults bool) (lineMasker, error) {
+
+	all := []string{}
+	if defaults {
+		all = append(all, defaultIgnorePatterns...)
+	}
+	all = append(all, patterns...)
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" && !strings.HasPrefix(line, "#") {
+				all = append(all, line)
+			}
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	masker := lineMasker{}
+	for _, pattern := range all {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ignore pattern %q: %w", pattern, err)
+		}
+		masker = append(masker, re)
+	}
+	return masker, nil
+}
+
+// runDiff implements the diff subcommand:
+//
+//	diff [-output-dir DIR] [-host mx1] [-ignore REGEX]... OLD NEW
+//
+// OLD and NEW are anything resolveRun accepts and default to previous and
+// latest. It returns whether anything changed or couldn't be compared, such as
+// a device that failed in either run.
+func runDiff(args []string, out io.Writer) (bool, error) {
+
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	outputDir := fs.String("output-dir", ".", "directory the runs were written under")
+	context := fs.Int("context", 3, "lines of context around each change")
+	defaults := fs.Bool("default-ignores", true, "ignore well known volatile lines such as uptime counters")
+	var ignores, ignoreFiles, hostList stringList
+	fs.Var(&ignores, "ignore", "regular expression for text to ignore when comparing, may be repeated")
+	fs.Var(&ignoreFiles, "ignore-file", "file of ignore patterns, one per line, may be repeated")
+	fs.Var(&hostList, "host", "only compare this device, may be repeated")
+	fs.Parse(args)
+
+	refs := []string{"previous", "latest"}
+	switch fs.NArg() {
+	case 0:
+	case 2:
+		refs = fs.Args()
+	default:
+		return false, fmt.Errorf("usage: diff [flags] [OLD NEW]")
+	}
+
+	masker, err := loadIgnorePatterns(ignores, ignoreFiles, *defaults)
+	if err != nil {
+		return false, err
+	}
+
+	manifests := make([]*runManifest, 2)
+	for i, ref := range refs {
+		runID, err := resolveRun(*outputDir, ref)
+		if err != nil {
+			return false, err
+		}
+		manifests[i], err = loadManifest(*outputDir, runID)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	hosts := map[string]bool{}
+	for _, host := range hostList {
+		hosts[host] = true
+	}
+
+	fmt.Fprintf(out, "Comparing %s with %s\n", manifests[0].RunID, manifests[1].RunID)
+	result := compareRuns(out, manifests[0], manifests[1], masker, *context, hosts)
+	printDiffSummary(out, result)
+	return len(result.Changed) > 0 || len(result.Incomplete) > 0 || len(result.OnlyOld) > 0 || len(result.OnlyNew) > 0, nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func identity(line string) string { return line }
+
+func TestDiffLinesRebuildsBothSides(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	words := []string{"a", "b", "c", "d"}
+	randomLines := func() []string {
+		lines := make([]string, random.Intn(12))
+		for i := range lines {
+			lines[i] = words[random.Intn(len(words))]
+		}
+		return lines
+	}
+
+	for i := 0; i < 500; i++ {
+		a, b := randomLines(), randomLines()
+		var gotA, gotB []string
+		for _, op := range diffLines(a, b, identity) {
+			if op.Kind != '+' {
+				gotA = append(gotA, op.Line)
+			}
+			if op.Kind != '-' {
+				gotB = append(gotB, op.Line)
+			}
+		}
+		if strings.Join(gotA, "") != strings.Join(a, "") || strings.Join(gotB, "") != strings.Join(b, "") {
+			t.Fatalf("diffLines(%q, %q) rebuilt %q and %q", a, b, gotA, gotB)
+		}
+	}
+}
+
+func TestDiffLinesLargeCaptures(t *testing.T) {
+	// Two long captures that differ every fifth line, like interface counters
+	old, new := make([]string, 20000), make([]string, 20000)
+	for i := range old {
+		old[i] = fmt.Sprintf("ge-0/0/%d", i)
+		new[i] = old[i]
+		if i%5 == 0 {
+			new[i] = fmt.Sprintf("Input packets: %d", i)
+		}
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	ops := diffLines(old, new, identity)
+	runtime.ReadMemStats(&after)
+
+	changed := 0
+	for _, op := range ops {
+		if op.Kind != ' ' {
+			changed++
+		}
+	}
+	if changed != 8000 {
+		t.Errorf("got %d changed lines, want 8000", changed)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 64<<20 {
+		t.Errorf("diffLines allocated %d MB", allocated>>20)
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	old := []string{"one", "two", "three", "four", "five", "six", "seven", "eight", "nine", "ten"}
+	new := []string{"one", "two", "THREE", "four", "five", "six", "seven", "eight", "nine", "ten", "eleven"}
+
+	got := unifiedDiff("old", "new", diffLines(old, new, identity), 1)
+	want := `--- old
++++ new
+@@ -2,3 +2,3 @@
+ two
+-three
++THREE
+ four
+@@ -10 +10,2 @@
+ ten
++eleven
+`
+	if got != want {
+		t.Errorf("unifiedDiff() =\n%s\nwant\n%s", got, want)
+	}
+
+	if got := unifiedDiff("old", "new", diffLines(old, old, identity), 3); got != "" {
+		t.Errorf("unifiedDiff() of identical input = %q", got)
+	}
+}
+
+func TestIgnorePatterns(t *testing.T) {
+	masker, err := loadIgnorePatterns([]string{`\d+ packets`}, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := []string{"ge-0/0/0 up", "System uptime: 3 days", "12 packets"}
+	new := []string{"ge-0/0/0 up", "System uptime: 4 days", "98 packets"}
+	if got := unifiedDiff("old", "new", diffLines(old, new, masker.key), 3); got != "" {
+		t.Errorf("volatile lines were reported:\n%s", got)
+	}
+
+	if _, err := loadIgnorePatterns([]string{"("}, nil, false); err == nil {
+		t.Error("want an error for an invalid pattern")
+	}
+}
+
+func testManifest(runID string, outputs map[string]string) *runManifest {
+	manifest := &runManifest{RunID: runID}
+	for host, output := range outputs {
+		record := newDeviceRecord(Device{Hostname: host}, time.Now())
+		record.RunID = runID
+		record.addCommand("show configuration", output, nil, time.Now())
+		manifest.Devices = append(manifest.Devices, record)
+	}
+	return manifest
+}
+
+func TestCompareRuns(t *testing.T) {
+	old := testManifest("run1", map[string]string{"mx1": "set a\nset b\n", "mx2": "set a\n", "mx3": "set a\n"})
+	new := testManifest("run2", map[string]string{"mx1": "set a\nset c\n", "mx2": "set a\n", "mx4": "set a\n"})
+
+	var out bytes.Buffer
+	result := compareRuns(&out, old, new, lineMasker{}, 3, nil)
+
+	if len(result.Changed) != 1 || strings.Join(result.Changed["mx1"], ",") != "show configuration" {
+		t.Errorf("Changed = %v", result.Changed)
+	}
+	if strings.Join(result.Unchanged, ",") != "mx2" || strings.Join(result.OnlyOld, ",") != "mx3" || strings.Join(result.OnlyNew, ",") != "mx4" {
+		t.Errorf("result = %+v", result)
+	}
+	if !strings.Contains(out.String(), "--- run1 mx1: show configuration\n+++ run2 mx1: show configuration\n") ||
+		!strings.Contains(out.String(), "-set b\n+set c\n") {
+		t.Errorf("diff output:\n%s", out.String())
+	}
+}
+
+func TestCompareRunsDeviceFailed(t *testing.T) {
+	old := testManifest("run1", map[string]string{"mx1": "set a\n", "mx2": "set a\n"})
+	new := testManifest("run2", map[string]string{"mx2": "set a\n"})
+	failed := newDeviceRecord(Device{Hostname: "mx1"}, time.Now())
+	failed.RunID = "run2"
+	failed.finish(errors.New("failed to connect: connection refused"))
+	new.Devices = append(new.Devices, failed)
+
+	var out bytes.Buffer
+	result := compareRuns(&out, old, new, lineMasker{}, 3, nil)
+
+	if strings.Join(result.Incomplete, ",") != "mx1" || strings.Join(result.Unchanged, ",") != "mx2" || len(result.Changed) != 0 {
+		t.Errorf("result = %+v", result)
+	}
+	skipped := strings.Join(result.Skipped, "\n")
+	for _, want := range []string{"mx1: failed in run2: failed to connect", `mx1: "show configuration" was not run in run2`} {
+		if !strings.Contains(skipped, want) {
+			t.Errorf("Skipped = %q, want %q", result.Skipped, want)
+		}
+	}
+}
+
+func TestResolveRun(t *testing.T) {
+	dir := t.TempDir()
+	runs := []string{"20261017T080000Z-aaaaaa", "20261018T101530Z-bbbbbb", "20261018T101530Z-cccccc"}
+	for _, run := range runs {
+		if err := writeManifest(dir, &runManifest{RunID: run}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writeManifest(dir, &runManifest{RunID: runs[0]}); err == nil {
+		t.Error("a run's manifest was overwritten")
+	}
+
+	cases := map[string]string{
+		"latest":               runs[2],
+		"previous":             runs[1],
+		"20261018T101530Z-bb":  runs[1],
+		"20261017":             runs[0],
+		"2026-10-17T23:00:00Z": runs[0],
+		"2026-10-18":           runs[2],
+	}
+	for ref, want := range cases {
+		if got, err := resolveRun(dir, ref); got != want || err != nil {
+			t.Errorf("resolveRun(%q) = %q, %v, want %q", ref, got, err, want)
+		}
+	}
+	for _, ref := range []string{"20261018T101530Z", "2026-10-16", "nonsense"} {
+		if got, err := resolveRun(dir, ref); err == nil {
+			t.Errorf("resolveRun(%q) = %q, want an error", ref, got)
+		}
+	}
+
+	if _, err := loadManifest(dir, runs[1]); err != nil {
+		t.Error(err)
+	}
+	if _, err := resolveRun(filepath.Join(dir, "empty"), "latest"); err == nil {
+		t.Error("want an error when there are no runs")
+	}
+}
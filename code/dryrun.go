@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// printDryRun shows what a run would do to each device without connecting to
+// any: the platform, transport, where the credentials come from, the jump
+// hosts and the commands as they'd be sent. Credentials are described, never
+// looked up, so nothing is prompted for. It returns the number of devices whose
+// commands couldn't be worked out.
+func printDryRun(out io.Writer, devices []Device, commandsFor commandSource, connect *connectFlags) int {
+
+	resolver := newCredentialResolver(connect.credentialDefaults())
+	auth := connect.Settings.Auth
+	auth.KeyFile = expandHome(auth.KeyFile)
+
+	failed := 0
+	for _, device := range devices {
+		fmt.Fprintf(out, "%s (%s)\n", device.Hostname, device.Source)
+
+		platform := device.Platform
+		if platform == platformAuto {
+			platform += ", detected when connecting"
+		}
+		fmt.Fprintf(out, "  platform:    %s\n", platform)
+		fmt.Fprintf(out, "  transport:   %s, port %d\n", device.Transport, device.Port)
+
+		login := []string{}
+		if key := auth.keyFor(device); key != "" {
+			login = append(login, "key "+key)
+		}
+		if auth.Agent != "" {
+			login = append(login, "agent "+auth.Agent)
+		}
+		if auth.usesPassword(device) {
+			login = append(login, "password from "+describeCredentials(resolver.specFor(device)))
+		} else {
+			// The username still comes from the credential source
+			login = append(login, "username from "+describeCredentials(resolver.specFor(device)))
+		}
+		fmt.Fprintf(out, "  credentials: %s\n", strings.Join(login, ", "))
+
+		if len(device.Jump) > 0 {
+			hops := make([]string, len(device.Jump))
+			for i, hop := range device.Jump {
+				hops[i] = hop.String()
+			}
+			fmt.Fprintf(out, "  jump:        %s\n", strings.Join(hops, " -> "))
+		}
+
+		if device.Platform == platformAuto {
+			fmt.Fprintf(out, "  commands:    chosen once the platform is detected\n\n")
+			continue
+		}
+		commands, err := commandsFor(device)
+		if err == nil {
+			commands, err = renderCommands(device, commands)
+		}
+		switch {
+		case err != nil:
+			failed++
+			fmt.Fprintf(out, "  commands:    Error: %v\n", err)
+		case len(commands) == 0:
+			failed++
+			fmt.Fprintf(out, "  commands:    none apply\n")
+		default:
+			fmt.Fprintf(out, "  commands:\n")
+			for _, command := range commands {
+				fmt.Fprintf(out, "    %s\n", command)
+			}
+		}
+		fmt.Fprintln(out)
+	}
+	return failed
+}
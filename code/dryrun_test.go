@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestPrintDryRun(t *testing.T) {
+	devices := []Device{
+		{Hostname: "mx1", Port: 22, Platform: "juniper_junos", Transport: transportSSH, Source: "inventory.yaml:3",
+			Vars: map[string]interface{}{"peer": "10.0.0.2"},
+			Jump: []jumpHost{{Host: "bastion", Port: 22, Username: "jumpuser"}, {Host: "10.0.0.5", Port: 2222}}},
+		{Hostname: "mx2", Port: netconfPort, Platform: "juniper_junos", Transport: transportNetconf, Source: "inventory.yaml:7",
+			Vars:        map[string]interface{}{"peer": "10.0.0.6"},
+			Credentials: &credentialSpec{Source: credentialsEnv, PasswordEnv: "CORE_PASSWORD"}},
+		{Hostname: "edge1", Port: 22, Platform: platformAuto, Transport: transportSSH, Source: "inventory.yaml:10"},
+		{Hostname: "mx3", Port: 22, Platform: "juniper_junos", Transport: transportSSH, Source: "inventory.yaml:12"},
+	}
+	commandsFor := func(device Device) ([]string, error) {
+		if device.Hostname == "mx3" {
+			return nil, fmt.Errorf("no command set for mx3")
+		}
+		return []string{"show version", "show route {{ .peer }}"}, nil
+	}
+	connect := &connectFlags{Credentials: credentialSpec{Source: credentialsProcess, Command: "fetch-secret --token s3cret"}}
+
+	var out strings.Builder
+	if failed := printDryRun(&out, devices, commandsFor, connect); failed != 1 {
+		t.Errorf("printDryRun() = %d failures, want 1", failed)
+	}
+	got := out.String()
+	for _, want := range []string{
+		"mx1 (inventory.yaml:3)\n  platform:    juniper_junos\n  transport:   ssh, port 22\n",
+		"  credentials: password from process (fetch-secret ...)\n",
+		"  jump:        jumpuser@bastion:22 -> 10.0.0.5:2222\n",
+		"    show route 10.0.0.2\n",
+		"  transport:   netconf, port 830\n  credentials: password from env (COLLECTOR_USERNAME, CORE_PASSWORD)\n",
+		"  platform:    auto, detected when connecting\n",
+		"  commands:    Error: no command set for mx3\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output is missing %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "s3cret") {
+		t.Errorf("output shows the credential process's arguments:\n%s", got)
+	}
+}
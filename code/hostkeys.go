@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Host key checking modes
+const (
+	// hostKeyStrict only connects to hosts whose key is already in known_hosts
+	hostKeyStrict = "strict"
+	// hostKeyTOFU records the key of a host seen for the first time, but still
+	// refuses a host whose recorded key has changed
+	hostKeyTOFU = "tofu"
+	// hostKeyOff accepts any host key, as the collector originally did
+	hostKeyOff = "off"
+)
+
+var (
+	// errHostKeyChanged marks a device presenting a different key to the one on record
+	errHostKeyChanged = errors.New("host key changed")
+	// errHostKeyUnknown marks a device with no known_hosts entry in strict mode
+	errHostKeyUnknown = errors.New("host key unknown")
+)
+
+// hostKeyPolicy decides how device host keys are verified
+type hostKeyPolicy struct {
+	Mode       string
+	KnownHosts string
+}
+
+// defaultKnownHostsFile returns ~/.ssh/known_hosts
+func defaultKnownHostsFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".ssh", "known_hosts")
+}
+
+// validate checks the mode and makes sure the known_hosts file is usable,
+// creating it for trust-on-first-use runs
+func (h *hostKeyPolicy) validate() error {
+
+	switch h.Mode {
+	case hostKeyOff:
+		return nil
+	case hostKeyStrict, hostKeyTOFU:
+	default:
+		return fmt.Errorf("unknown host key mode %q (expected %s, %s or %s)", h.Mode, hostKeyStrict, hostKeyTOFU, hostKeyOff)
+	}
+
+	if h.KnownHosts == "" {
+		return fmt.Errorf("host key mode %s needs a known_hosts file", h.Mode)
+	}
+	h.KnownHosts = expandHome(h.KnownHosts)
+
+	_, err := os.Stat(h.KnownHosts)
+	if err == nil {
+		return nil
+	}
+	if !os.IsNotExist(err) || h.Mode == hostKeyStrict {
+		return fmt.Errorf("known_hosts file: %w", err)
+	}
+
+	// First run in tofu mode, start an empty file for ssh to record keys in
+	if err := os.MkdirAll(filepath.Dir(h.KnownHosts), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(h.KnownHosts, nil, 0600)
+}
+
+// sshOptions returns the OpenSSH options that enforce the policy
+func (h hostKeyPolicy) sshOptions() []string {
+
+	switch h.Mode {
+	case hostKeyStrict:
+		return []string{"-o", "StrictHostKeyChecking=yes", "-o", "UserKnownHostsFile=" + h.KnownHosts}
+	case hostKeyTOFU:
+		return []string{"-o", "StrictHostKeyChecking=accept-new", "-o", "UserKnownHostsFile=" + h.KnownHosts}
+	}
+	return []string{"-o", "StrictHostKeyChecking=no", "-o", "UserKnownHostsFile=/dev/null"}
+}
+
+// explain turns ssh's generic "host key verification failed" into an error
+// saying whether the device's key changed or was never recorded
+func (h hostKeyPolicy) explain(device Device, err error) error {
+
+	if h.Mode == hostKeyOff || !strings.Contains(strings.ToLower(err.Error()), "host key verification failed") {
+		return err
+	}
+
+	recorded, lookupErr := h.recordedKeys(device)
+	if lookupErr != nil {
+		return err
+	}
+	if len(recorded) == 0 {
+		return fmt.Errorf("%w: %s has no entry in %s; add it or use -host-key-check %s", errHostKeyUnknown, device.Hostname, h.KnownHosts, hostKeyTOFU)
+	}
+	return fmt.Errorf("%w: %s no longer matches %s; if the change is expected remove the old entry with ssh-keygen -R",
+		errHostKeyChanged, device.Hostname, strings.Join(recorded, ", "))
+}
+
+// recordedKeys returns the known_hosts locations (file:line) holding keys for
+// the device. knownhosts only reports what it has on record when a key fails
+// to match, so it is asked about a throwaway key that never will.
+func (h hostKeyPolicy) recordedKeys(device Device) ([]string, error) {
+
+	callback, err := knownhosts.New(h.KnownHosts)
+	if err != nil {
+		return nil, err
+	}
+
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	probe, err := ssh.NewPublicKey(public)
+	if err != nil {
+		return nil, err
+	}
+
+	// Entries may be recorded by address as well as name, so include it when it resolves
+	remote := &net.TCPAddr{IP: net.IPv4zero, Port: device.Port}
+	if ips, err := net.LookupIP(device.Hostname); err == nil && len(ips) > 0 {
+		remote.IP = ips[0]
+	}
+
+	address := net.JoinHostPort(device.Hostname, strconv.Itoa(device.Port))
+	var keyErr *knownhosts.KeyError
+	if err := callback(address, remote, probe); !errors.As(err, &keyErr) {
+		return nil, err
+	}
+
+	recorded := []string{}
+	for _, want := range keyErr.Want {
+		recorded = append(recorded, fmt.Sprintf("%s:%d", want.Filename, want.Line))
+	}
+	return recorded, nil
+}
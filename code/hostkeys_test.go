@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/scrapli/scrapligo/util"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestHostKeyPolicyExplain(t *testing.T) {
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize("mx1.example.invalid:22")}, key)
+	if err := os.WriteFile(path, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	policy := hostKeyPolicy{Mode: hostKeyStrict, KnownHosts: path}
+	sshErr := fmt.Errorf("%w: encountered error output during in channel ssh authentication, error: 'host key verification failed'", util.ErrConnectionError)
+
+	err = policy.explain(Device{Hostname: "mx1.example.invalid", Port: 22}, sshErr)
+	if !errors.Is(err, errHostKeyChanged) {
+		t.Errorf("known host: err = %v, want errHostKeyChanged", err)
+	}
+
+	err = policy.explain(Device{Hostname: "mx2.example.invalid", Port: 22}, sshErr)
+	if !errors.Is(err, errHostKeyUnknown) {
+		t.Errorf("unknown host: err = %v, want errHostKeyUnknown", err)
+	}
+
+	other := errors.New("permission denied")
+	if err := policy.explain(Device{Hostname: "mx1.example.invalid", Port: 22}, other); err != other {
+		t.Errorf("unrelated error was rewritten: %v", err)
+	}
+}
+
+func TestHostKeyPolicyValidate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ssh", "known_hosts")
+
+	strict := hostKeyPolicy{Mode: hostKeyStrict, KnownHosts: path}
+	if err := strict.validate(); err == nil {
+		t.Error("strict mode accepted a missing known_hosts file")
+	}
+
+	tofu := hostKeyPolicy{Mode: hostKeyTOFU, KnownHosts: path}
+	if err := tofu.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("tofu mode did not create known_hosts: %v", err)
+	}
+
+	if err := (&hostKeyPolicy{Mode: "yolo"}).validate(); err == nil {
+		t.Error("unknown mode accepted")
+	}
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const defaultPort = 22
+
+// defaultPlatform is used for devices that don't name one, matching the
+// collector's original Juniper-only behaviour
+const defaultPlatform = "juniper_junos"
+
+// Device is a single entry in the inventory
+type Device struct {
+	Hostname string
+	Port     int
+	// Platform is always a scrapligo platform name once loaded
+	Platform string
+	Groups   []string
+	// SSHKey is a private key file for this device, overriding -ssh-key
+	SSHKey string
+	// Transport is transportSSH or transportNetconf
+	Transport string
+	// Credentials comes from the first of the device's groups that sets it,
+	// nil means the run-wide credential source applies
+	Credentials *credentialSpec
+	// Jump is the chain of jump hosts to reach the device through, taken
+	// from the first of its groups that sets one
+	Jump []jumpHost
+	// Vars holds the group vars overlaid with the device's own vars
+	Vars map[string]interface{}
+	// Source is where the device was defined, e.g. "inventory.yaml:12"
+	Source string
+}
+
+// inventoryGroup holds settings shared by every device in a group
+type inventoryGroup struct {
+	Vars        map[string]interface{} `yaml:"vars"`
+	Credentials *credentialSpec        `yaml:"credentials"`
+	Jump        []jumpHost             `yaml:"jump"`
+}
+
+// Inventory is the loaded and validated list of devices to collect from
+type Inventory struct {
+	Devices []Device
+	Groups  map[string]inventoryGroup
+}
+
+// inventoryIssue is a single validation problem tied to a line of the inventory file
+type inventoryIssue struct {
+	File    string
+	Line    int
+	Message string
+}
+
+func (i inventoryIssue) String() string {
+	return fmt.Sprintf("%s:%d: %s", i.File, i.Line, i.Message)
+}
+
+// inventoryError collects every validation problem found while loading, so
+// they can all be fixed in one go rather than one per run
+type inventoryError struct {
+	Issues []inventoryIssue
+}
+
+func (e *inventoryError) Error() string {
+
+	lines := make([]string, len(e.Issues))
+	for i, issue := range e.Issues {
+		lines[i] = issue.String()
+	}
+	return fmt.Sprintf("invalid inventory:\n  %s", strings.Join(lines, "\n  "))
+}
+
+func (e *inventoryError) add(file string, line int, format string, args ...interface{}) {
+	e.Issues = append(e.Issues, inventoryIssue{file, line, fmt.Sprintf(format, args...)})
+}
+
+// orNil returns nil when no issues were recorded, so callers can return it directly
+func (e *inventoryError) orNil() error {
+	if len(e.Issues) == 0 {
+		return nil
+	}
+	return e
+}
+
+// loadInventory reads an inventory file, picking the format from its extension.
+// YAML (.yaml/.yml) and CSV (.csv) carry full device details; anything else is
+// read as the legacy one-hostname-per-line devices.txt format.
+func loadInventory(path string) (*Inventory, error) {
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var inventory *Inventory
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		inventory, err = parseYAMLInventory(path, content)
+	case ".csv":
+		inventory, err = parseCSVInventory(path, content)
+	default:
+		inventory, err = parseTextInventory(path, content)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return inventory, nil
+}
+
+// rawDevice is a device as written in the inventory, before defaults and validation
+type rawDevice struct {
+	Hostname  string
+	Port      string
+	Platform  string
+	Groups    []string
+	SSHKey    string
+	Transport string
+	Vars      map[string]interface{}
+	Line      int
+}
+
+// yamlInventory mirrors the top level of a YAML inventory file
+type yamlInventory struct {
+	Groups  map[string]yaml.Node `yaml:"groups"`
+	Devices []yaml.Node          `yaml:"devices"`
+}
+
+// yamlDeviceFields lists the keys accepted on a YAML device entry
+var yamlDeviceFields = map[string]bool{
+	"hostname":  true,
+	"port":      true,
+	"platform":  true,
+	"groups":    true,
+	"ssh_key":   true,
+	"transport": true,
+	"vars":      true,
+}
+
+func parseYAMLInventory(path string, content []byte) (*Inventory, error) {
+
+	var doc yamlInventory
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	issues := &inventoryError{}
+	var groups map[string]inventoryGroup
+	if doc.Groups != nil {
+		groups = map[string]inventoryGroup{}
+	}
+	for name, node := range doc.Groups {
+		var group inventoryGroup
+		if err := node.Decode(&group); err != nil {
+			issues.add(path, node.Line, "group %s: %v", name, err)
+			continue
+		}
+		if group.Credentials != nil {
+			if _, err := newCredentialProvider(*group.Credentials); err != nil {
+				issues.add(path, node.Line, "group %s: %v", name, err)
+			}
+		}
+		for i := range group.Jump {
+			hop := &group.Jump[i]
+			if hop.Port == 0 {
+				hop.Port = defaultPort
+			}
+			hop.SSHKey = expandHome(hop.SSHKey)
+			if hop.Host == "" {
+				issues.add(path, node.Line, "group %s: jump host %d has no host", name, i+1)
+			} else if hop.Port < 1 || hop.Port > 65535 {
+				issues.add(path, node.Line, "group %s: jump host %s has invalid port %d", name, hop.Host, hop.Port)
+			}
+			if hop.SSHKey != "" {
+				if _, err := os.Stat(hop.SSHKey); err != nil {
+					issues.add(path, node.Line, "group %s: jump host %s: ssh_key: %v", name, hop.Host, err)
+				}
+			}
+		}
+		groups[name] = group
+	}
+
+	raw := []rawDevice{}
+	for _, node := range doc.Devices {
+		if node.Kind != yaml.MappingNode {
+			issues.add(path, node.Line, "device entry must be a mapping")
+			continue
+		}
+
+		// Catch typos such as "hostnme" instead of silently ignoring them
+		for i := 0; i < len(node.Content); i += 2 {
+			key := node.Content[i]
+			if !yamlDeviceFields[key.Value] {
+				issues.add(path, key.Line, "unknown device field %q", key.Value)
+			}
+		}
+
+		var device struct {
+			Hostname  string                 `yaml:"hostname"`
+			Port      string                 `yaml:"port"`
+			Platform  string                 `yaml:"platform"`
+			Groups    []string               `yaml:"groups"`
+			SSHKey    string                 `yaml:"ssh_key"`
+			Transport string                 `yaml:"transport"`
+			Vars      map[string]interface{} `yaml:"vars"`
+		}
+		if err := node.Decode(&device); err != nil {
+			issues.add(path, node.Line, "%v", err)
+			continue
+		}
+		raw = append(raw, rawDevice{device.Hostname, device.Port, device.Platform, device.Groups, device.SSHKey, device.Transport, device.Vars, node.Line})
+	}
+
+	return buildInventory(path, raw, groups, issues)
+}
+
+func parseCSVInventory(path string, content []byte) (*Inventory, error) {
+
+	reader := csv.NewReader(strings.NewReader(string(content)))
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%s: reading header: %w", path, err)
+	}
+	for i := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(header[i]))
+	}
+
+	issues := &inventoryError{}
+	hasHostname := false
+	for _, column := range header {
+		hasHostname = hasHostname || column == "hostname"
+	}
+	if !hasHostname {
+		issues.add(path, 1, "header has no hostname column")
+		return nil, issues
+	}
+
+	raw := []rawDevice{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				issues.add(path, parseErr.Line, "%v", parseErr.Err)
+				continue
+			}
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		line, _ := reader.FieldPos(0)
+		device := rawDevice{Line: line, Vars: map[string]interface{}{}}
+		for i, value := range record {
+			value = strings.TrimSpace(value)
+			switch header[i] {
+			case "hostname":
+				device.Hostname = value
+			case "port":
+				device.Port = value
+			case "platform":
+				device.Platform = value
+			case "groups":
+				// Groups are separated by semicolons or spaces within the one cell
+				device.Groups = strings.FieldsFunc(value, func(r rune) bool { return r == ';' || r == ' ' })
+			case "ssh_key":
+				device.SSHKey = value
+			case "transport":
+				device.Transport = value
+			default:
+				// Any other column becomes a device var
+				if value != "" {
+					device.Vars[header[i]] = value
+				}
+			}
+		}
+		raw = append(raw, device)
+	}
+
+	return buildInventory(path, raw, nil, issues)
+}
+
+func parseTextInventory(path string, content []byte) (*Inventory, error) {
+
+	raw := []rawDevice{}
+	for i, line := range strings.Split(string(content), "\n") {
+		hostname := strings.TrimSpace(line)
+		if hostname == "" || strings.HasPrefix(hostname, "#") {
+			continue
+		}
+		raw = append(raw, rawDevice{Hostname: hostname, Line: i + 1})
+	}
+
+	return buildInventory(path, raw, nil, &inventoryError{})
+}
+
+// buildInventory applies defaults and group vars to the raw devices and
+// validates them, adding any problems to issues
+func buildInventory(path string, raw []rawDevice, groups map[string]inventoryGroup, issues *inventoryError) (*Inventory, error) {
+
+	inventory := &Inventory{Groups: groups}
+	if inventory.Groups == nil {
+		inventory.Groups = map[string]inventoryGroup{}
+	}
+
+	seen := map[string]int{}
+	for _, r := range raw {
+		device := Device{
+			Hostname:  strings.TrimSpace(r.Hostname),
+			Port:      defaultPort,
+			Platform:  defaultPlatform,
+			Groups:    r.Groups,
+			SSHKey:    expandHome(r.SSHKey),
+			Transport: transportSSH,
+			Vars:      map[string]interface{}{},
+			Source:    fmt.Sprintf("%s:%d", path, r.Line),
+		}
+
+		if device.Hostname == "" {
+			issues.add(path, r.Line, "device has no hostname")
+		} else if strings.ContainsAny(device.Hostname, " \t/") {
+			issues.add(path, r.Line, "invalid hostname %q", device.Hostname)
+		} else if first, ok := seen[device.Hostname]; ok {
+			issues.add(path, r.Line, "duplicate hostname %q, first defined on line %d", device.Hostname, first)
+		} else {
+			seen[device.Hostname] = r.Line
+		}
+
+		if r.Port != "" {
+			port, err := strconv.Atoi(r.Port)
+			if err != nil || port < 1 || port > 65535 {
+				issues.add(path, r.Line, "invalid port %q", r.Port)
+			}
+			device.Port = port
+		}
+
+		if device.SSHKey != "" {
+			if _, err := os.Stat(device.SSHKey); err != nil {
+				issues.add(path, r.Line, "ssh_key: %v", err)
+			}
+		}
+
+		if r.Platform != "" {
+			platform, ok := resolvePlatform(r.Platform)
+			if !ok {
+				issues.add(path, r.Line, "unknown platform %q (expected one of %s)", r.Platform, knownPlatformNames())
+			}
+			device.Platform = platform
+		}
+
+		if r.Transport != "" {
+			device.Transport = strings.ToLower(r.Transport)
+		}
+		switch device.Transport {
+		case transportSSH:
+		case transportNetconf:
+			if !netconfPlatforms[device.Platform] {
+				issues.add(path, r.Line, "transport netconf is not supported on platform %s", device.Platform)
+			}
+			if r.Port == "" {
+				device.Port = netconfPort
+			}
+		default:
+			issues.add(path, r.Line, "unknown transport %q (expected %s or %s)", r.Transport, transportSSH, transportNetconf)
+		}
+
+		// Group vars apply in the order the groups are listed, device vars win over all of them
+		for _, name := range device.Groups {
+			group, ok := inventory.Groups[name]
+			if !ok {
+				if groups != nil {
+					issues.add(path, r.Line, "unknown group %q", name)
+				}
+				continue
+			}
+			for key, value := range group.Vars {
+				device.Vars[key] = value
+			}
+			if device.Credentials == nil {
+				device.Credentials = group.Credentials
+			}
+			if device.Jump == nil {
+				device.Jump = group.Jump
+			}
+		}
+		for key, value := range r.Vars {
+			device.Vars[key] = value
+		}
+
+		inventory.Devices = append(inventory.Devices, device)
+	}
+
+	if err := issues.orNil(); err != nil {
+		sort.SliceStable(issues.Issues, func(i, j int) bool { return issues.Issues[i].Line < issues.Issues[j].Line })
+		return nil, err
+	}
+	return inventory, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeInventory(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadYAMLInventory(t *testing.T) {
+	path := writeInventory(t, "inventory.yaml", `
+groups:
+  pe:
+    vars:
+      role: pe
+      asn: 65000
+devices:
+  - hostname: mx1
+    platform: junos
+    groups: [pe]
+    vars:
+      asn: 65001
+  - hostname: csr1
+    port: 2222
+    platform: cisco_iosxe
+`)
+
+	inventory, err := loadInventory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inventory.Devices) != 2 {
+		t.Fatalf("got %d devices, want 2", len(inventory.Devices))
+	}
+
+	mx1 := inventory.Devices[0]
+	if mx1.Platform != "juniper_junos" || mx1.Port != 22 {
+		t.Errorf("mx1 = %+v", mx1)
+	}
+	wantVars := map[string]interface{}{"role": "pe", "asn": 65001}
+	if !reflect.DeepEqual(mx1.Vars, wantVars) {
+		t.Errorf("mx1.Vars = %v, want %v", mx1.Vars, wantVars)
+	}
+	if !strings.HasSuffix(mx1.Source, "inventory.yaml:8") {
+		t.Errorf("mx1.Source = %q", mx1.Source)
+	}
+
+	csr1 := inventory.Devices[1]
+	if csr1.Platform != "cisco_iosxe" || csr1.Port != 2222 {
+		t.Errorf("csr1 = %+v", csr1)
+	}
+}
+
+func TestYAMLInventoryErrorsHaveLineNumbers(t *testing.T) {
+	path := writeInventory(t, "inventory.yaml", `devices:
+  - hostname: mx1
+    platform: junos
+  - hostname: mx1
+    platform: ios_classic
+  - platform: eos
+    prot: 22
+`)
+
+	_, err := loadInventory(path)
+	var invErr *inventoryError
+	if !errors.As(err, &invErr) {
+		t.Fatalf("err = %v, want *inventoryError", err)
+	}
+
+	lines := []int{}
+	for _, issue := range invErr.Issues {
+		lines = append(lines, issue.Line)
+	}
+	// duplicate + unknown platform on line 4, unknown field on 7, missing hostname on 6
+	want := []int{4, 4, 6, 7}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("issue lines = %v, want %v\n%v", lines, want, err)
+	}
+}
+
+func TestLoadCSVInventory(t *testing.T) {
+	path := writeInventory(t, "inventory.csv", `hostname,port,platform,groups,site
+mx1,,junos,core;pe,lon1
+# decommissioned
+eos1,2222,arista_eos,access,
+bad,99999,junos,,
+`)
+
+	_, err := loadInventory(path)
+	var invErr *inventoryError
+	if !errors.As(err, &invErr) || len(invErr.Issues) != 1 || invErr.Issues[0].Line != 5 {
+		t.Fatalf("err = %v, want one issue on line 5", err)
+	}
+
+	path = writeInventory(t, "inventory.csv", `hostname,port,platform,groups,site
+mx1,,junos,core;pe,lon1
+eos1,2222,arista_eos,access,
+`)
+	inventory, err := loadInventory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mx1 := inventory.Devices[0]
+	if !reflect.DeepEqual(mx1.Groups, []string{"core", "pe"}) || mx1.Vars["site"] != "lon1" {
+		t.Errorf("mx1 = %+v", mx1)
+	}
+	if eos1 := inventory.Devices[1]; eos1.Port != 2222 || eos1.Platform != "arista_eos" {
+		t.Errorf("eos1 = %+v", eos1)
+	}
+}
+
+func TestLoadTextInventory(t *testing.T) {
+	path := writeInventory(t, "devices.txt", "mx1\nmx2\n\nmx3 \n")
+
+	inventory, err := loadInventory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, device := range inventory.Devices {
+		if device.Platform != defaultPlatform {
+			t.Errorf("%s platform = %q", device.Hostname, device.Platform)
+		}
+	}
+	if len(inventory.Devices) != 3 || inventory.Devices[2].Hostname != "mx3" {
+		t.Errorf("devices = %+v", inventory.Devices)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// jumpHost is one SSH hop between the collector and a device, set per
+// inventory group as a chain walked in order:
+//
+//	jump:
+//	  - host: bastion.example.net
+//	    username: jumpuser
+//	    ssh_key: ~/.ssh/bastion
+//	  - host: 10.0.0.5
+//	    port: 2222
+type jumpHost struct {
+	Host string `yaml:"host"`
+	Port int    `yaml:"port"`
+	// Username defaults to the local user, as with plain ssh
+	Username string `yaml:"username"`
+	// SSHKey defaults to -ssh-key
+	SSHKey string `yaml:"ssh_key"`
+}
+
+func (j jumpHost) String() string {
+	host := j.Host
+	if j.Username != "" {
+		host = j.Username + "@" + host
+	}
+	return fmt.Sprintf("%s:%d", host, j.Port)
+}
+
+// jumpAlias names the ssh_config entry for the last hop of chain. It is derived
+// from the whole chain, so a bastion reached by two different routes gets one
+// entry per route.
+func jumpAlias(chain []jumpHost) string {
+
+	parts := make([]string, len(chain))
+	for i, hop := range chain {
+		parts[i] = hop.String() + " " + hop.SSHKey
+	}
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\n")))
+	return fmt.Sprintf("collector-jump-%x", sum[:6])
+}
+
+// jumpConfig renders an ssh_config with one Host entry per hop used by the
+// devices. ssh hands its -F file on to the ssh it starts for each -J hop, which
+// is the only way to give every hop its own user, key and host key settings.
+func jumpConfig(devices []Device, settings connectSettings) (string, error) {
+
+	var config strings.Builder
+	written := map[string]bool{}
+	seconds := int(settings.Limits.Connect.Seconds())
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	for _, device := range devices {
+		for i, hop := range device.Jump {
+			alias := jumpAlias(device.Jump[:i+1])
+			if written[alias] {
+				continue
+			}
+			written[alias] = true
+
+			key := hop.SSHKey
+			if key == "" {
+				key = settings.Auth.KeyFile
+			}
+			if key == "" && settings.Auth.Agent == "" {
+				return "", fmt.Errorf("jump host %s needs an ssh_key, -ssh-key or -ssh-agent", hop)
+			}
+			if key != "" && settings.Auth.Agent == "" {
+				encrypted, err := keyNeedsPassphrase(key)
+				if err != nil {
+					return "", fmt.Errorf("jump host %s: %w", hop, err)
+				}
+				if encrypted {
+					return "", fmt.Errorf("jump host %s: %s is encrypted, load it into an ssh-agent and use -ssh-agent", hop, key)
+				}
+			}
+
+			fmt.Fprintf(&config, "Host %s\n", alias)
+			fmt.Fprintf(&config, "  HostName %s\n", hop.Host)
+			fmt.Fprintf(&config, "  Port %d\n", hop.Port)
+			if hop.Username != "" {
+				fmt.Fprintf(&config, "  User %s\n", hop.Username)
+			}
+			if key != "" {
+				fmt.Fprintf(&config, "  IdentityFile %s\n", key)
+				fmt.Fprintf(&config, "  IdentitiesOnly yes\n")
+			}
+			if settings.Auth.Agent != "" {
+				fmt.Fprintf(&config, "  IdentityAgent %s\n", settings.Auth.Agent)
+			} else {
+				fmt.Fprintf(&config, "  IdentityAgent none\n")
+			}
+			// A hop prompting for a password would be answered with the device's
+			// password by scrapligo, so hops only ever use keys
+			fmt.Fprintf(&config, "  BatchMode yes\n")
+			fmt.Fprintf(&config, "  PreferredAuthentications publickey\n")
+			fmt.Fprintf(&config, "  ConnectTimeout %d\n", seconds)
+			hostKeyOptions := settings.HostKeys.sshOptions()
+			for j := 1; j < len(hostKeyOptions); j += 2 {
+				fmt.Fprintf(&config, "  %s\n", strings.Replace(hostKeyOptions[j], "=", " ", 1))
+			}
+			if i > 0 {
+				fmt.Fprintf(&config, "  ProxyJump %s\n", jumpAlias(device.Jump[:i]))
+			}
+			config.WriteString("\n")
+		}
+	}
+	return config.String(), nil
+}
+
+// writeJumpConfig writes the jump host ssh_config to a private temporary file,
+// returning "" when no device goes through a jump host
+func writeJumpConfig(devices []Device, settings connectSettings) (string, error) {
+
+	config, err := jumpConfig(devices, settings)
+	if err != nil || config == "" {
+		return "", err
+	}
+
+	file, err := os.CreateTemp("", "collector-ssh-config-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := file.WriteString(config); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestJumpConfigChainsHops(t *testing.T) {
+	key := writeTestKey(t).path
+	defaultKey := writeTestKey(t).path
+	chain := []jumpHost{
+		{Host: "bastion1", Port: 22, Username: "jump", SSHKey: key},
+		{Host: "10.0.0.5", Port: 2222},
+	}
+	settings := connectSettings{
+		Limits:   timeouts{Connect: 5 * time.Second},
+		HostKeys: hostKeyPolicy{Mode: hostKeyStrict, KnownHosts: "/tmp/known_hosts"},
+		Auth:     sshAuth{KeyFile: defaultKey},
+	}
+	devices := []Device{
+		{Hostname: "mx1", Jump: chain},
+		{Hostname: "mx2", Jump: chain},
+	}
+
+	config, err := jumpConfig(devices, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, last := jumpAlias(chain[:1]), jumpAlias(chain)
+	if strings.Count(config, "Host collector-jump-") != 2 {
+		t.Errorf("want one entry per hop shared by both devices, got:\n%s", config)
+	}
+	for _, want := range []string{
+		"Host " + first + "\n  HostName bastion1\n  Port 22\n  User jump\n  IdentityFile " + key,
+		"Host " + last + "\n  HostName 10.0.0.5\n  Port 2222\n  IdentityFile " + defaultKey,
+		"StrictHostKeyChecking yes\n  UserKnownHostsFile /tmp/known_hosts\n  ProxyJump " + first,
+		"BatchMode yes",
+	} {
+		if !strings.Contains(config, want) {
+			t.Errorf("config is missing %q:\n%s", want, config)
+		}
+	}
+
+	settings.JumpConfig = "/tmp/jump_config"
+	args := strings.Join(sshOpenArgs(devices[0], "netops", settings), " ")
+	if !strings.Contains(args, "-F /tmp/jump_config -J "+last) {
+		t.Errorf("sshOpenArgs() = %s", args)
+	}
+
+	if _, err := jumpConfig([]Device{{Jump: []jumpHost{{Host: "bastion1", Port: 22}}}}, connectSettings{}); err == nil {
+		t.Error("want an error for a jump host with no key or agent")
+	}
+}
+
+// TestJumpHostTunnel runs the real ssh binary through two stand-in bastions
+// to a stand-in device, each a minimal SSH server on localhost
+func TestJumpHostTunnel(t *testing.T) {
+	if _, err := exec.LookPath("ssh"); err != nil {
+		t.Skip("ssh binary not available")
+	}
+
+	bastionKey := writeTestKey(t)
+	deviceKey := writeTestKey(t)
+
+	device := startTestSSHServer(t, deviceKey.public, false)
+	inner := startTestSSHServer(t, bastionKey.public, true)
+	outer := startTestSSHServer(t, bastionKey.public, true)
+
+	target := Device{
+		Hostname: "127.0.0.1",
+		Port:     device,
+		Jump: []jumpHost{
+			{Host: "127.0.0.1", Port: outer, Username: "jump", SSHKey: bastionKey.path},
+			{Host: "127.0.0.1", Port: inner, Username: "jump", SSHKey: bastionKey.path},
+		},
+	}
+	settings := connectSettings{
+		Limits:   timeouts{Connect: 5 * time.Second},
+		HostKeys: hostKeyPolicy{Mode: hostKeyOff},
+		Auth:     sshAuth{KeyFile: deviceKey.path},
+	}
+
+	var err error
+	settings.JumpConfig, err = writeJumpConfig([]Device{target}, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(settings.JumpConfig)
+
+	args := append(sshOpenArgs(target, "netops", settings), "-o", "BatchMode=yes", "show version")
+	output, err := exec.Command("ssh", args...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("ssh: %v\n%s", err, output)
+	}
+	if !strings.Contains(string(output), "ran: show version") {
+		t.Errorf("output = %q", output)
+	}
+}
+
+type testKey struct {
+	path   string
+	public ssh.PublicKey
+}
+
+func writeTestKey(t *testing.T) testKey {
+	t.Helper()
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "id_ecdsa")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	public, err := ssh.NewPublicKey(&private.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKey{path, public}
+}
+
+// startTestSSHServer listens on a localhost port for clients holding
+// authorized's key. A bastion forwards direct-tcpip channels, anything else
+// answers exec requests with "ran: <command>".
+func startTestSSHServer(t *testing.T, authorized ssh.PublicKey, bastion bool) int {
+	t.Helper()
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) == string(authorized.Marshal()) {
+				return nil, nil
+			}
+			return nil, io.EOF
+		},
+	}
+	hostKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestSSH(conn, config, bastion)
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func serveTestSSH(conn net.Conn, config *ssh.ServerConfig, bastion bool) {
+
+	server, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer server.Close()
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		switch {
+		case bastion && newChannel.ChannelType() == "direct-tcpip":
+			var target struct {
+				Host       string
+				Port       uint32
+				OriginHost string
+				OriginPort uint32
+			}
+			if err := ssh.Unmarshal(newChannel.ExtraData(), &target); err != nil {
+				newChannel.Reject(ssh.ConnectionFailed, err.Error())
+				continue
+			}
+			upstream, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+			if err != nil {
+				newChannel.Reject(ssh.ConnectionFailed, err.Error())
+				continue
+			}
+			channel, reqs, err := newChannel.Accept()
+			if err != nil {
+				upstream.Close()
+				continue
+			}
+			go ssh.DiscardRequests(reqs)
+			go func() {
+				io.Copy(channel, upstream)
+				channel.Close()
+			}()
+			go func() {
+				io.Copy(upstream, channel)
+				upstream.Close()
+			}()
+		case !bastion && newChannel.ChannelType() == "session":
+			channel, reqs, err := newChannel.Accept()
+			if err != nil {
+				continue
+			}
+			go func() {
+				for req := range reqs {
+					if req.Type != "exec" {
+						req.Reply(false, nil)
+						continue
+					}
+					req.Reply(true, nil)
+					command := string(req.Payload[4:])
+					io.WriteString(channel, "ran: "+command+"\n")
+					status := make([]byte, 4)
+					binary.BigEndian.PutUint32(status, 0)
+					channel.SendRequest("exit-status", false, status)
+					channel.Close()
+				}
+			}()
+		default:
+			newChannel.Reject(ssh.UnknownChannelType, "not supported")
+		}
+	}
+}
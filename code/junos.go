@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// deviceFacts are normalised details about a device, decoded from structured
+// command output rather than scraped from text
+type deviceFacts struct {
+	Hostname   string          `json:"hostname,omitempty" yaml:"hostname,omitempty"`
+	Model      string          `json:"model,omitempty" yaml:"model,omitempty"`
+	Version    string          `json:"version,omitempty" yaml:"version,omitempty"`
+	Serial     string          `json:"serial,omitempty" yaml:"serial,omitempty"`
+	Interfaces []interfaceFact `json:"interfaces,omitempty" yaml:"interfaces,omitempty"`
+	BGPPeers   []bgpPeerFact   `json:"bgp_peers,omitempty" yaml:"bgp_peers,omitempty"`
+}
+
+// interfaceFact is one physical or logical interface and its state
+type interfaceFact struct {
+	Name      string   `json:"name" yaml:"name"`
+	Admin     string   `json:"admin" yaml:"admin"`
+	Oper      string   `json:"oper" yaml:"oper"`
+	Addresses []string `json:"addresses,omitempty" yaml:"addresses,omitempty"`
+}
+
+// bgpPeerFact is one BGP neighbour and its session state
+type bgpPeerFact struct {
+	Address     string `json:"address" yaml:"address"`
+	AS          string `json:"as" yaml:"as"`
+	State       string `json:"state" yaml:"state"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+}
+
+// junosText is how Junos JSON carries every leaf: [{"data": "value"}]. Some
+// commands pad the value with newlines, which String trims.
+type junosText []struct {
+	Data string `json:"data"`
+}
+
+func (t junosText) String() string {
+	if len(t) == 0 {
+		return ""
+	}
+	return strings.TrimSpace(t[0].Data)
+}
+
+type junosSoftwareInformation struct {
+	SoftwareInformation []struct {
+		HostName     junosText `json:"host-name"`
+		ProductModel junosText `json:"product-model"`
+		JunosVersion junosText `json:"junos-version"`
+	} `json:"software-information"`
+}
+
+type junosChassisInventory struct {
+	ChassisInventory []struct {
+		Chassis []struct {
+			Description  junosText `json:"description"`
+			SerialNumber junosText `json:"serial-number"`
+		} `json:"chassis"`
+	} `json:"chassis-inventory"`
+}
+
+type junosInterfaceState struct {
+	Name          junosText `json:"name"`
+	AdminStatus   junosText `json:"admin-status"`
+	OperStatus    junosText `json:"oper-status"`
+	AddressFamily []struct {
+		InterfaceAddress []struct {
+			IfaLocal junosText `json:"ifa-local"`
+		} `json:"interface-address"`
+	} `json:"address-family"`
+}
+
+type junosInterfaceInformation struct {
+	InterfaceInformation []struct {
+		PhysicalInterface []struct {
+			junosInterfaceState
+			LogicalInterface []junosInterfaceState `json:"logical-interface"`
+		} `json:"physical-interface"`
+	} `json:"interface-information"`
+}
+
+type junosBGPInformation struct {
+	BGPInformation []struct {
+		BGPPeer []struct {
+			PeerAddress junosText `json:"peer-address"`
+			PeerAS      junosText `json:"peer-as"`
+			PeerState   junosText `json:"peer-state"`
+			Description junosText `json:"description"`
+		} `json:"bgp-peer"`
+	} `json:"bgp-information"`
+}
+
+// junosFactCommands are sent to Junos devices when facts are wanted, each
+// decoded into the facts by its function
+var junosFactCommands = []struct {
+	Command string
+	decode  func(output []byte, facts *deviceFacts) error
+}{
+	{"show version | display json", decodeJunosVersion},
+	{"show chassis hardware | display json", decodeJunosChassis},
+	{"show interfaces terse | display json", decodeJunosInterfaces},
+	{"show bgp summary | display json", decodeJunosBGP},
+}
+
+func decodeJunosVersion(output []byte, facts *deviceFacts) error {
+
+	var reply junosSoftwareInformation
+	if err := json.Unmarshal(output, &reply); err != nil {
+		return err
+	}
+	if len(reply.SoftwareInformation) == 0 {
+		return fmt.Errorf("no software-information in the reply")
+	}
+	info := reply.SoftwareInformation[0]
+	facts.Hostname = info.HostName.String()
+	facts.Model = info.ProductModel.String()
+	facts.Version = info.JunosVersion.String()
+	return nil
+}
+
+func decodeJunosChassis(output []byte, facts *deviceFacts) error {
+
+	var reply junosChassisInventory
+	if err := json.Unmarshal(output, &reply); err != nil {
+		return err
+	}
+	if len(reply.ChassisInventory) == 0 || len(reply.ChassisInventory[0].Chassis) == 0 {
+		return fmt.Errorf("no chassis in the reply")
+	}
+	facts.Serial = reply.ChassisInventory[0].Chassis[0].SerialNumber.String()
+	return nil
+}
+
+func decodeJunosInterfaces(output []byte, facts *deviceFacts) error {
+
+	var reply junosInterfaceInformation
+	if err := json.Unmarshal(output, &reply); err != nil {
+		return err
+	}
+	if len(reply.InterfaceInformation) == 0 {
+		return fmt.Errorf("no interface-information in the reply")
+	}
+
+	fact := func(state junosInterfaceState) interfaceFact {
+		f := interfaceFact{Name: state.Name.String(), Admin: state.AdminStatus.String(), Oper: state.OperStatus.String()}
+		for _, family := range state.AddressFamily {
+			for _, address := range family.InterfaceAddress {
+				if local := address.IfaLocal.String(); local != "" {
+					f.Addresses = append(f.Addresses, local)
+				}
+			}
+		}
+		return f
+	}
+	facts.Interfaces = []interfaceFact{}
+	for _, physical := range reply.InterfaceInformation[0].PhysicalInterface {
+		facts.Interfaces = append(facts.Interfaces, fact(physical.junosInterfaceState))
+		for _, logical := range physical.LogicalInterface {
+			facts.Interfaces = append(facts.Interfaces, fact(logical))
+		}
+	}
+	return nil
+}
+
+func decodeJunosBGP(output []byte, facts *deviceFacts) error {
+
+	var reply junosBGPInformation
+	if err := json.Unmarshal(output, &reply); err != nil {
+		return err
+	}
+	// Devices not running BGP answer with an error instead of bgp-information
+	if len(reply.BGPInformation) == 0 {
+		return fmt.Errorf("no bgp-information in the reply")
+	}
+	facts.BGPPeers = []bgpPeerFact{}
+	for _, peer := range reply.BGPInformation[0].BGPPeer {
+		facts.BGPPeers = append(facts.BGPPeers, bgpPeerFact{
+			Address:     peer.PeerAddress.String(),
+			AS:          peer.PeerAS.String(),
+			State:       peer.PeerState.String(),
+			Description: peer.Description.String(),
+		})
+	}
+	return nil
+}
+
+// withJunosFacts adds the fact commands to whatever else Junos devices are sent
+func withJunosFacts(commandsFor commandSource) commandSource {
+	return func(device Device) ([]string, error) {
+		commands, err := commandsFor(device)
+		// NETCONF devices get XML replies, which the fact decoders don't read
+		if err != nil || device.Platform != "juniper_junos" || device.Transport == transportNetconf {
+			return commands, err
+		}
+		facts := make([]string, len(junosFactCommands))
+		for i, fact := range junosFactCommands {
+			facts[i] = fact.Command
+		}
+		return mergeCommands(append([]string{}, commands...), facts), nil
+	}
+}
+
+// gatherJunosFacts decodes the fact commands in a record into its Facts. A
+// reply that won't decode is noted as the command's parse error. It returns
+// the number of such failures.
+func gatherJunosFacts(record *deviceRecord) int {
+
+	if record.Platform != "juniper_junos" || record.Transport == transportNetconf {
+		return 0
+	}
+	facts := &deviceFacts{}
+	failures := 0
+	for i, result := range record.Commands {
+		for _, fact := range junosFactCommands {
+			if fact.Command != result.Command || result.Error != "" {
+				continue
+			}
+			if err := fact.decode([]byte(result.Output), facts); err != nil {
+				record.Commands[i].ParseError = err.Error()
+				failures++
+			}
+		}
+	}
+	record.Facts = facts
+	return failures
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+const junosVersionJSON = `{
+    "software-information" : [
+    {
+        "host-name" : [{"data" : "mx1"}],
+        "product-model" : [{"data" : "mx960"}],
+        "product-name" : [{"data" : "mx960"}],
+        "junos-version" : [{"data" : "21.4R3.15"}]
+    }
+    ]
+}
+`
+
+const junosChassisJSON = `{
+    "chassis-inventory" : [
+    {
+        "chassis" : [
+        {
+            "name" : [{"data" : "Chassis"}],
+            "serial-number" : [{"data" : "JN1234567AFA"}],
+            "description" : [{"data" : "MX960"}]
+        }
+        ]
+    }
+    ]
+}
+`
+
+const junosInterfacesJSON = `{
+    "interface-information" : [
+    {
+        "physical-interface" : [
+        {
+            "name" : [{"data" : "\nge-0/0/0\n"}],
+            "admin-status" : [{"data" : "\nup\n"}],
+            "oper-status" : [{"data" : "\nup\n"}],
+            "logical-interface" : [
+            {
+                "name" : [{"data" : "\nge-0/0/0.0\n"}],
+                "admin-status" : [{"data" : "\nup\n"}],
+                "oper-status" : [{"data" : "\nup\n"}],
+                "address-family" : [
+                {
+                    "address-family-name" : [{"data" : "\ninet\n"}],
+                    "interface-address" : [{"ifa-local" : [{"data" : "\n10.0.0.1/30\n"}]}]
+                }
+                ]
+            }
+            ]
+        },
+        {
+            "name" : [{"data" : "\nge-0/0/1\n"}],
+            "admin-status" : [{"data" : "\ndown\n"}],
+            "oper-status" : [{"data" : "\ndown\n"}]
+        }
+        ]
+    }
+    ]
+}
+`
+
+const junosBGPJSON = `{
+    "bgp-information" : [
+    {
+        "peer-count" : [{"data" : "2"}],
+        "bgp-peer" : [
+        {
+            "peer-address" : [{"data" : "10.0.0.2"}],
+            "peer-as" : [{"data" : "65001"}],
+            "peer-state" : [{"data" : "Established"}],
+            "description" : [{"data" : "core-1"}]
+        },
+        {
+            "peer-address" : [{"data" : "10.0.0.6"}],
+            "peer-as" : [{"data" : "65002"}],
+            "peer-state" : [{"data" : "Active"}]
+        }
+        ]
+    }
+    ]
+}
+`
+
+func TestWithJunosFacts(t *testing.T) {
+	commandsFor := withJunosFacts(func(device Device) ([]string, error) {
+		return []string{"show version | display json", "show system uptime"}, nil
+	})
+
+	commands, err := commandsFor(Device{Platform: "juniper_junos"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commands) != 5 || commands[0] != "show version | display json" || commands[1] != "show system uptime" {
+		t.Errorf("junos commands = %q", commands)
+	}
+
+	commands, _ = commandsFor(Device{Platform: "arista_eos"})
+	if len(commands) != 2 {
+		t.Errorf("other platforms were sent fact commands: %q", commands)
+	}
+}
+
+func TestGatherJunosFacts(t *testing.T) {
+	record := newDeviceRecord(Device{Hostname: "mx1", Platform: "juniper_junos"}, time.Now())
+	record.addCommand("show version | display json", junosVersionJSON, nil, time.Now())
+	record.addCommand("show chassis hardware | display json", junosChassisJSON, nil, time.Now())
+	record.addCommand("show interfaces terse | display json", junosInterfacesJSON, nil, time.Now())
+	record.addCommand("show bgp summary | display json", "error: the bgp subsystem is not running\n", nil, time.Now())
+
+	if failures := gatherJunosFacts(record); failures != 1 {
+		t.Errorf("gatherJunosFacts() = %d failures, want 1", failures)
+	}
+	facts := record.Facts
+	if facts.Hostname != "mx1" || facts.Model != "mx960" || facts.Version != "21.4R3.15" || facts.Serial != "JN1234567AFA" {
+		t.Errorf("facts = %+v", facts)
+	}
+	if len(facts.Interfaces) != 3 {
+		t.Fatalf("interfaces = %+v", facts.Interfaces)
+	}
+	logical := facts.Interfaces[1]
+	if logical.Name != "ge-0/0/0.0" || logical.Oper != "up" || len(logical.Addresses) != 1 || logical.Addresses[0] != "10.0.0.1/30" {
+		t.Errorf("logical interface = %+v", logical)
+	}
+	if facts.Interfaces[2].Admin != "down" {
+		t.Errorf("ge-0/0/1 = %+v", facts.Interfaces[2])
+	}
+	if record.Commands[3].ParseError == "" || facts.BGPPeers != nil {
+		t.Errorf("bgp = %+v, peers %+v", record.Commands[3], facts.BGPPeers)
+	}
+
+	record = newDeviceRecord(Device{Hostname: "mx2", Platform: "juniper_junos"}, time.Now())
+	record.addCommand("show bgp summary | display json", junosBGPJSON, nil, time.Now())
+	gatherJunosFacts(record)
+	peers := record.Facts.BGPPeers
+	if len(peers) != 2 || peers[0].Description != "core-1" || peers[1].State != "Active" || peers[1].AS != "65002" {
+		t.Errorf("peers = %+v", peers)
+	}
+}
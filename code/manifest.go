@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// manifestDir is where each run's manifest is kept under the output directory
+const manifestDir = "runs"
+
+// runManifest records everything a run collected, whatever the output layout,
+// so later commands such as diff can find a run by its ID
+type runManifest struct {
+	RunID    string          `json:"run_id"`
+	Started  time.Time       `json:"started"`
+	Finished time.Time       `json:"finished"`
+	Devices  []*deviceRecord `json:"devices"`
+}
+
+// device returns the record for a hostname, or nil if the run didn't include it
+func (m *runManifest) device(hostname string) *deviceRecord {
+	for _, record := range m.Devices {
+		if record.Hostname == hostname {
+			return record
+		}
+	}
+	return nil
+}
+
+func manifestPath(outputDir string, runID string) string {
+	return filepath.Join(outputDir, manifestDir, runID+".json")
+}
+
+// writeManifest saves the run's manifest, never replacing an existing one
+func writeManifest(outputDir string, manifest *runManifest) error {
+
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeOutputFile(manifestPath(outputDir, manifest.RunID), append(content, '\n'))
+}
+
+func loadManifest(outputDir string, runID string) (*runManifest, error) {
+
+	content, err := os.ReadFile(manifestPath(outputDir, runID))
+	if err != nil {
+		return nil, err
+	}
+	manifest := &runManifest{}
+	if err := json.Unmarshal(content, manifest); err != nil {
+		return nil, fmt.Errorf("run %s: %w", runID, err)
+	}
+	return manifest, nil
+}
+
+// listRuns returns the IDs of every run with a manifest, oldest first. Run IDs
+// start with their UTC start time, so sorting them as text sorts by time.
+func listRuns(outputDir string) ([]string, error) {
+
+	files, err := filepath.Glob(filepath.Join(outputDir, manifestDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	runs := make([]string, len(files))
+	for i, file := range files {
+		runs[i] = strings.TrimSuffix(filepath.Base(file), ".json")
+	}
+	sort.Strings(runs)
+	return runs, nil
+}
+
+// resolveRun finds the run a user means by:
+//
+//	latest, previous           the newest run, or the one before it
+//	20261018T101530Z-3f9a1c    a run ID, or any unique prefix of one
+//	2026-10-18T10:15:30Z       the newest run started at or before a time or date
+func resolveRun(outputDir string, ref string) (string, error) {
+
+	runs, err := listRuns(outputDir)
+	if err != nil {
+		return "", err
+	}
+	if len(runs) == 0 {
+		return "", fmt.Errorf("no runs found in %s", filepath.Join(outputDir, manifestDir))
+	}
+
+	switch ref {
+	case "latest":
+		return runs[len(runs)-1], nil
+	case "previous":
+		if len(runs) < 2 {
+			return "", fmt.Errorf("there is no run before %s", runs[0])
+		}
+		return runs[len(runs)-2], nil
+	}
+
+	matches := []string{}
+	for _, run := range runs {
+		if run == ref {
+			return run, nil
+		}
+		if strings.HasPrefix(run, ref) {
+			matches = append(matches, run)
+		}
+	}
+	if len(matches) == 1 {
+		return matches[0], nil
+	}
+	if len(matches) > 1 {
+		return "", fmt.Errorf("%q matches %d runs: %s", ref, len(matches), strings.Join(matches, ", "))
+	}
+
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"} {
+		at, err := time.ParseInLocation(layout, ref, time.UTC)
+		if err != nil {
+			continue
+		}
+		// A bare date means any time that day
+		if layout == "2006-01-02" {
+			at = at.Add(24*time.Hour - time.Second)
+		}
+		stamp := at.UTC().Format(snapshotTimeFormat)
+		found := ""
+		for _, run := range runs {
+			if strings.SplitN(run, "-", 2)[0] <= stamp {
+				found = run
+			}
+		}
+		if found == "" {
+			return "", fmt.Errorf("no run started at or before %s", ref)
+		}
+		return found, nil
+	}
+	return "", fmt.Errorf("no run matches %q", ref)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+
+	"github.com/scrapli/scrapligo/driver/netconf"
+	"github.com/scrapli/scrapligo/driver/opoptions"
+	"github.com/scrapli/scrapligo/response"
+)
+
+// Device transports
+const (
+	// transportSSH screen scrapes the device's CLI, as the collector always has
+	transportSSH = "ssh"
+	// transportNetconf runs RPCs over the NETCONF SSH subsystem
+	transportNetconf = "netconf"
+)
+
+// netconfPort is where devices listen for NETCONF unless the inventory says otherwise
+const netconfPort = 830
+
+// netconfPlatforms lists the platforms NETCONF collection is supported on
+var netconfPlatforms = map[string]bool{
+	"juniper_junos": true,
+}
+
+func openNetconf(device Device, creds credentials, settings connectSettings) (*netconf.Driver, error) {
+
+	d, err := netconf.NewDriver(device.Hostname, connectionOptions(device, creds, settings)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create netconf driver %w", err)
+	}
+
+	err = d.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open netconf session %w", settings.HostKeys.explain(device, err))
+	}
+
+	return d, nil
+}
+
+// netconfDriver runs commands as NETCONF RPCs, see netconfRPC
+type netconfDriver struct {
+	d *netconf.Driver
+}
+
+func (n netconfDriver) run(cmd string) (commandReply, error) {
+
+	source, rpc := netconfRPC(cmd)
+	var r *response.NetconfResponse
+	var err error
+	if source != "" {
+		r, err = n.d.GetConfig(source)
+	} else {
+		r, err = n.d.RPC(opoptions.WithFilter(rpc))
+	}
+	if err != nil {
+		return commandReply{}, err
+	}
+	return netconfResult(r)
+}
+
+func (n netconfDriver) close() { n.d.Close() }
+
+func (n netconfDriver) abort() { n.d.Channel.Close() }
+
+// netconfResult turns an rpc-error in a reply into errCommandRejected, keeping
+// the reply so the error details are in the output
+func netconfResult(r *response.NetconfResponse) (commandReply, error) {
+	reply := commandReply{Output: r.Result, Elapsed: r.ElapsedTime}
+	if r.Failed != nil {
+		return reply, fmt.Errorf("%w: the reply holds an rpc-error", errCommandRejected)
+	}
+	return reply, nil
+}
+
+// netconfRPC reads a command line from a command set for a NETCONF device.
+// "get-config" fetches the running configuration, "get-config candidate" the
+// named datastore, and a line starting with "<" is sent as a raw RPC. Anything
+// else is taken to be a CLI command and wrapped in Junos' <command> RPC, so
+// existing command sets work over NETCONF with XML replies. It returns either
+// the datastore to get or the RPC to send.
+func netconfRPC(cmd string) (source string, rpc string) {
+
+	fields := strings.Fields(cmd)
+	if len(fields) > 0 && fields[0] == "get-config" {
+		if len(fields) > 1 {
+			return fields[1], ""
+		}
+		return "running", ""
+	}
+	if strings.HasPrefix(cmd, "<") {
+		return "", cmd
+	}
+
+	var escaped strings.Builder
+	xml.EscapeText(&escaped, []byte(cmd))
+	return "", "<command>" + escaped.String() + "</command>"
+}
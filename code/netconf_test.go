@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/scrapli/scrapligo/response"
+)
+
+func TestNetconfRPC(t *testing.T) {
+	cases := []struct {
+		cmd, source, rpc string
+	}{
+		{"get-config", "running", ""},
+		{"get-config candidate", "candidate", ""},
+		{"<get-system-information/>", "", "<get-system-information/>"},
+		{"show route 10.0.0.0/8 | match <x>", "", "<command>show route 10.0.0.0/8 | match &lt;x&gt;</command>"},
+	}
+	for _, c := range cases {
+		source, rpc := netconfRPC(c.cmd)
+		if source != c.source || rpc != c.rpc {
+			t.Errorf("netconfRPC(%q) = %q, %q, want %q, %q", c.cmd, source, rpc, c.source, c.rpc)
+		}
+	}
+}
+
+func TestNetconfResult(t *testing.T) {
+	reply := "<rpc-reply><rpc-error><error-message>syntax error</error-message></rpc-error></rpc-reply>"
+	r := &response.NetconfResponse{Result: reply, ElapsedTime: 0.5, Failed: &response.OperationError{ErrorString: reply}}
+	got, err := netconfResult(r)
+	if got.Output != reply || got.Elapsed != 0.5 || !errors.Is(err, errCommandRejected) {
+		t.Errorf("netconfResult() = %+v, %v", got, err)
+	}
+	if _, err := netconfResult(&response.NetconfResponse{Result: "<rpc-reply><ok/></rpc-reply>"}); err != nil {
+		t.Errorf("netconfResult() = %v for a good reply", err)
+	}
+}
+
+func TestNetconfTransportInventory(t *testing.T) {
+	path := writeInventory(t, "inventory.yaml", `
+devices:
+  - hostname: mx1
+    platform: junos
+    transport: netconf
+  - hostname: mx2
+    platform: junos
+    port: 8300
+    transport: NETCONF
+  - hostname: mx3
+`)
+	inventory, err := loadInventory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices := inventory.Devices
+	if devices[0].Transport != transportNetconf || devices[0].Port != netconfPort {
+		t.Errorf("mx1 = %+v", devices[0])
+	}
+	if devices[1].Transport != transportNetconf || devices[1].Port != 8300 {
+		t.Errorf("mx2 = %+v", devices[1])
+	}
+	if devices[2].Transport != transportSSH || devices[2].Port != defaultPort {
+		t.Errorf("mx3 = %+v", devices[2])
+	}
+
+	path = writeInventory(t, "inventory.csv", "hostname,platform,transport\neos1,eos,netconf\nmx1,junos,telnet\n")
+	_, err = loadInventory(path)
+	if err == nil {
+		t.Fatal("want errors for an unsupported platform and an unknown transport")
+	}
+	for _, want := range []string{"inventory.csv:2: transport netconf is not supported on platform arista_eos", `inventory.csv:3: unknown transport "telnet"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+}
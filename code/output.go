@@ -0,0 +1,289 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// defaultLayout writes one file per device per run
+const defaultLayout = "{{host}}_{{run_id}}.{{ext}}"
+
+// snapshotTimeFormat is ISO-8601 basic format in UTC, which sorts by time as plain text
+const snapshotTimeFormat = "20060102T150405Z"
+
+// newRunID names a run after its start time, with a random suffix so two runs
+// started in the same second still differ, e.g. 20261018T101530Z-3f9a1c
+func newRunID(started time.Time) (string, error) {
+
+	suffix := make([]byte, 3)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s-%x", started.UTC().Format(snapshotTimeFormat), suffix), nil
+}
+
+// outputLayout decides where results are written. The layout is a template
+// for the path under Root, built from these functions:
+//
+//	{{host}} {{platform}} {{group}}   the device's hostname, platform and first group
+//	{{date}} {{time}}                 when the run started, in UTC
+//	{{run_id}}                        the run's ID, its start time plus a random suffix
+//	{{command}} {{command_slug}}      the command, raw or made safe for a file name
+//	{{ext}}                           txt, json or yaml for the output format
+//
+// A layout using command or command_slug writes one file per command,
+// otherwise each device gets one file holding every command.
+type outputLayout struct {
+	Root       string
+	Started    time.Time
+	RunID      string
+	template   *template.Template
+	perCommand bool
+}
+
+// layoutFuncs are placeholders so a layout can be parsed, the real values are
+// bound for each file in render
+var layoutFuncs = template.FuncMap{
+	"host":         func() string { return "" },
+	"platform":     func() string { return "" },
+	"group":        func() string { return "" },
+	"date":         func() string { return "" },
+	"time":         func() string { return "" },
+	"run_id":       func() string { return "" },
+	"command":      func() string { return "" },
+	"command_slug": func() string { return "" },
+	"ext":          func() string { return "" },
+}
+
+// newOutputLayout parses and checks a layout. Several formats need {{ext}} in
+// the layout so they don't write over one another, and every layout needs
+// {{run_id}} or {{time}} since results are never overwritten, so a layout
+// that came out the same in the next run would fail every device then.
+func newOutputLayout(root string, layout string, formats []string, started time.Time, runID string) (*outputLayout, error) {
+
+	tmpl, err := template.New("layout").Funcs(layoutFuncs).Parse(layout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output layout: %w", err)
+	}
+	if filepath.IsAbs(layout) {
+		return nil, fmt.Errorf("output layout %q must be relative to the output directory", layout)
+	}
+	l := &outputLayout{Root: root, Started: started, RunID: runID, template: tmpl}
+
+	// Render a sample to find out what the layout depends on
+	sample := Device{Hostname: "host"}
+	first, err := l.render(sample, "show version", formatText)
+	if err != nil {
+		return nil, err
+	}
+	otherCommand, _ := l.render(sample, "show interfaces", formatText)
+	otherFormat, _ := l.render(sample, "show version", formatJSON)
+	l.perCommand = first != otherCommand
+	if len(formats) > 1 && first == otherFormat {
+		return nil, fmt.Errorf("output layout %q needs {{ext}} to write more than one format", layout)
+	}
+	nextRun := *l
+	nextRun.Started, nextRun.RunID = started.Add(time.Hour), runID+"-next"
+	if next, _ := nextRun.render(sample, "show version", formatText); next == first {
+		return nil, fmt.Errorf("output layout %q needs {{run_id}} or {{time}} so the next run doesn't collide with this one", layout)
+	}
+	return l, nil
+}
+
+// render returns the path a device's output, or one command's output, is written to
+func (l *outputLayout) render(device Device, command string, format string) (string, error) {
+
+	group := ""
+	if len(device.Groups) > 0 {
+		group = device.Groups[0]
+	}
+	values := map[string]string{
+		"host":         device.Hostname,
+		"platform":     device.Platform,
+		"group":        group,
+		"date":         l.Started.UTC().Format("2006-01-02"),
+		"time":         l.Started.UTC().Format(snapshotTimeFormat),
+		"run_id":       l.RunID,
+		"command":      command,
+		"command_slug": slugCommand(command),
+		"ext":          strings.TrimPrefix(formatExtensions[format], "."),
+	}
+	funcs := template.FuncMap{}
+	for name, value := range values {
+		value := value
+		funcs[name] = func() string { return value }
+	}
+
+	tmpl, err := l.template.Clone()
+	if err != nil {
+		return "", err
+	}
+	var path strings.Builder
+	if err := tmpl.Funcs(funcs).Execute(&path, nil); err != nil {
+		return "", fmt.Errorf("output layout: %w", err)
+	}
+
+	// Keep every file inside the output root whatever the layout expands to. An
+	// empty value such as {{group}} for a device in no group just drops out.
+	relative := filepath.Clean(strings.TrimLeft(path.String(), "/"))
+	if relative == "." || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("output layout expands to %q, which is outside the output directory", path.String())
+	}
+	return filepath.Join(l.Root, relative), nil
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+// slugCommand turns a command into something safe to use as a file name,
+// e.g. "file list /var/tmp" becomes "file_list_var_tmp"
+func slugCommand(command string) string {
+
+	slug := strings.Trim(unsafeFileChars.ReplaceAllString(command, "_"), "_.")
+	if len(slug) > 100 {
+		slug = strings.TrimRight(slug[:100], "_.")
+	}
+	if slug == "" {
+		slug = "command"
+	}
+	return slug
+}
+
+// commandFile is what gets written for one command under a per-command layout
+func commandFile(result commandResult, format string) ([]byte, error) {
+
+	if format == formatText {
+		if result.Error == "" {
+			return []byte(result.Output + "\n"), nil
+		}
+		content := "ERROR: " + result.Error + "\n"
+		// Keep what the device said when it refused the command, as text() does
+		if result.Rejected && result.Output != "" {
+			content += result.Output + "\n"
+		}
+		return []byte(content), nil
+	}
+	return encodeValue(result, format)
+}
+
+// parsedSuffix is added to a text file's name for its sidecar of parsed rows
+const parsedSuffix = ".parsed.json"
+
+// parsedCommand is what a parsed sidecar holds for one command
+type parsedCommand struct {
+	Command    string                   `json:"command"`
+	Parsed     []map[string]interface{} `json:"parsed,omitempty"`
+	ParseError string                   `json:"parse_error,omitempty"`
+}
+
+// parsedSidecar returns the JSON written next to a text file for the parsed
+// rows of its commands, or nil if none of them went through a template. Text
+// output has nowhere else to keep them.
+func parsedSidecar(results []commandResult, perCommand bool) ([]byte, error) {
+
+	parsed := []parsedCommand{}
+	for _, result := range results {
+		if result.Parsed != nil || result.ParseError != "" {
+			parsed = append(parsed, parsedCommand{result.Command, result.Parsed, result.ParseError})
+		}
+	}
+	switch {
+	case len(parsed) == 0:
+		return nil, nil
+	case perCommand:
+		return encodeValue(parsed[0], formatJSON)
+	}
+	return encodeValue(parsed, formatJSON)
+}
+
+// write saves the record once per format following the layout, creating
+// directories as needed, and returns the paths written. When text is the only
+// format, parsed rows go in a .parsed.json file next to each text file.
+func (l *outputLayout) write(device Device, record *deviceRecord, formats []string) ([]string, error) {
+
+	// The platform may have been detected while connecting
+	device.Platform = record.Platform
+
+	textOnly := true
+	for _, format := range formats {
+		if format != formatText {
+			textOnly = false
+		}
+	}
+
+	written := []string{}
+	writeFiles := func(path string, content []byte, results []commandResult) error {
+		if err := writeOutputFile(path, content); err != nil {
+			return err
+		}
+		written = append(written, path)
+		if !textOnly {
+			return nil
+		}
+		sidecar, err := parsedSidecar(results, l.perCommand)
+		if err != nil || sidecar == nil {
+			return err
+		}
+		if err := writeOutputFile(path+parsedSuffix, sidecar); err != nil {
+			return err
+		}
+		written = append(written, path+parsedSuffix)
+		return nil
+	}
+
+	for _, format := range formats {
+		if !l.perCommand {
+			content, err := record.encode(format)
+			if err != nil {
+				return written, err
+			}
+			path, err := l.render(device, "", format)
+			if err != nil {
+				return written, err
+			}
+			if err := writeFiles(path, content, record.Commands); err != nil {
+				return written, err
+			}
+			continue
+		}
+
+		seen := map[string]bool{}
+		for _, result := range record.Commands {
+			path, err := l.render(device, result.Command, format)
+			if err != nil {
+				return written, err
+			}
+			// Two commands that slug the same get numbered rather than overwritten
+			base, ext := strings.TrimSuffix(path, filepath.Ext(path)), filepath.Ext(path)
+			for n := 2; seen[path]; n++ {
+				path = fmt.Sprintf("%s_%d%s", base, n, ext)
+			}
+			seen[path] = true
+
+			content, err := commandFile(result, format)
+			if err != nil {
+				return written, err
+			}
+			if err := writeFiles(path, content, []commandResult{result}); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func writeOutputFile(path string, content []byte) error {
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory %w", err)
+	}
+	if err := WriteStringToFile(path, string(content)); err != nil {
+		return fmt.Errorf("failed to write to file %w", err)
+	}
+	return nil
+}
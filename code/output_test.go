@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlugCommand(t *testing.T) {
+	cases := map[string]string{
+		"file list /var/tmp":               "file_list_var_tmp",
+		"show configuration | display set": "show_configuration_display_set",
+		"show interfaces ge-0/0/0.0":       "show_interfaces_ge-0_0_0.0",
+		"../../etc/passwd":                 "etc_passwd",
+		"|":                                "command",
+		strings.Repeat("show very long command ", 10): strings.TrimRight(strings.Repeat("show_very_long_command_", 10)[:100], "_"),
+	}
+	for command, want := range cases {
+		if got := slugCommand(command); got != want {
+			t.Errorf("slugCommand(%q) = %q, want %q", command, got, want)
+		}
+	}
+}
+
+func TestOutputLayoutPerCommand(t *testing.T) {
+	root := t.TempDir()
+	started := time.Date(2026, 10, 18, 10, 15, 30, 0, time.UTC)
+	layout, err := newOutputLayout(root, "{{date}}/{{run_id}}/{{host}}/{{command_slug}}.{{ext}}", []string{formatText, formatJSON}, started, "run")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	device := Device{Hostname: "mx1"}
+	record := newDeviceRecord(device, started)
+	record.addCommand("file list /var/tmp", "/var/tmp/:\n", nil, started)
+	record.addCommand("file  list /var/tmp", "again\n", nil, started)
+
+	written, err := layout.write(device, record, []string{formatText, formatJSON})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"2026-10-18/run/mx1/file_list_var_tmp.txt",
+		"2026-10-18/run/mx1/file_list_var_tmp_2.txt",
+		"2026-10-18/run/mx1/file_list_var_tmp.json",
+		"2026-10-18/run/mx1/file_list_var_tmp_2.json",
+	}
+	if len(written) != len(want) {
+		t.Fatalf("wrote %q, want %q", written, want)
+	}
+	for i, path := range want {
+		if written[i] != filepath.Join(root, path) {
+			t.Errorf("written[%d] = %q, want %q", i, written[i], path)
+		}
+	}
+	content, err := os.ReadFile(filepath.Join(root, want[0]))
+	if err != nil || string(content) != "/var/tmp/:\n\n" {
+		t.Errorf("content = %q, %v", content, err)
+	}
+}
+
+func TestCommandFileKeepsRejectedReply(t *testing.T) {
+	record := newDeviceRecord(Device{Hostname: "mx1"}, time.Now())
+	output := "                 ^\nsyntax error, expecting <command>."
+	record.addReply("show intefaces", commandReply{Output: output}, &cliError{Class: cliErrorSyntax, Line: "syntax error, expecting <command>."}, time.Now())
+	record.addReply("show version", commandReply{}, errors.New("failed to send input to device EOF"), time.Now())
+
+	content, err := commandFile(record.Commands[0], formatText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "ERROR: syntax error, expecting <command>. [syntax]\n" + output + "\n"; string(content) != want {
+		t.Errorf("rejected command file = %q, want %q", content, want)
+	}
+	if content, _ := commandFile(record.Commands[1], formatText); string(content) != "ERROR: failed to send input to device EOF\n" {
+		t.Errorf("failed command file = %q", content)
+	}
+}
+
+func TestOutputLayoutPerDevice(t *testing.T) {
+	root := t.TempDir()
+	layout, err := newOutputLayout(root, "{{group}}/{{host}}_{{run_id}}.{{ext}}", []string{formatYAML}, time.Now(), "run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layout.perCommand {
+		t.Error("a layout without a command should write one file per device")
+	}
+
+	device := Device{Hostname: "mx1", Groups: []string{"core"}}
+	record := newDeviceRecord(device, time.Now())
+	record.addCommand("show version", "Junos: 21.4R3", nil, time.Now())
+	written, err := layout.write(device, record, []string{formatYAML})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != 1 || written[0] != filepath.Join(root, "core", "mx1_run.yaml") {
+		t.Errorf("written = %q", written)
+	}
+}
+
+func TestOutputLayoutParsedSidecar(t *testing.T) {
+	root := t.TempDir()
+	layout, err := newOutputLayout(root, "{{host}}_{{run_id}}.{{ext}}", []string{formatText}, time.Now(), "run")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	device := Device{Hostname: "mx1"}
+	record := newDeviceRecord(device, time.Now())
+	record.addCommand("show version", "Model: mx960", nil, time.Now())
+	record.Commands[0].Parsed = []map[string]interface{}{{"MODEL": "mx960"}}
+	record.addCommand("file list /var/tmp", "/var/tmp/:", nil, time.Now())
+
+	written, err := layout.write(device, record, []string{formatText})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sidecar := filepath.Join(root, "mx1_run.txt"+parsedSuffix)
+	if len(written) != 2 || written[1] != sidecar {
+		t.Fatalf("written = %q", written)
+	}
+	content, err := os.ReadFile(sidecar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), `"MODEL": "mx960"`) || strings.Contains(string(content), "file list") {
+		t.Errorf("sidecar = %s", content)
+	}
+
+	// JSON and YAML results already hold the parsed rows
+	layout, err = newOutputLayout(t.TempDir(), "{{host}}_{{run_id}}.{{ext}}", []string{formatText, formatJSON}, time.Now(), "run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written, err := layout.write(device, record, []string{formatText, formatJSON}); err != nil || len(written) != 2 {
+		t.Errorf("written = %q, %v", written, err)
+	}
+}
+
+func TestOutputLayoutRejected(t *testing.T) {
+	cases := map[string][]string{
+		"{{host}}.txt":          {formatText, formatJSON},
+		"../{{host}}.{{ext}}":   {formatText},
+		"/tmp/{{host}}.{{ext}}": {formatText},
+		"{{hostname}}":          {formatText},
+		// Each of these would collide with the next run
+		"{{date}}/{{host}}/{{command_slug}}.{{ext}}": {formatText},
+		"{{host}}.{{ext}}":                           {formatText},
+	}
+	for layout, formats := range cases {
+		if _, err := newOutputLayout(t.TempDir(), layout, formats, time.Now(), "run"); err == nil {
+			t.Errorf("newOutputLayout(%q, %v) succeeded", layout, formats)
+		}
+	}
+}
+
+func TestSnapshotNaming(t *testing.T) {
+	started := time.Date(2026, 10, 18, 12, 15, 30, 0, time.FixedZone("CEST", 2*60*60))
+	runID, err := newRunID(started)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(runID, "20261018T101530Z-") || len(runID) != len("20261018T101530Z-")+6 {
+		t.Errorf("newRunID() = %q", runID)
+	}
+	if other, _ := newRunID(started); other == runID {
+		t.Errorf("two runs in the same second both got %q", runID)
+	}
+
+	root := t.TempDir()
+	layout, err := newOutputLayout(root, defaultLayout, []string{formatText}, started, runID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	device := Device{Hostname: "mx1"}
+	record := newDeviceRecord(device, started)
+	record.addCommand("show version", "Junos: 21.4R3", nil, started)
+
+	written, err := layout.write(device, record, []string{formatText})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written[0] != filepath.Join(root, "mx1_"+runID+".txt") {
+		t.Errorf("written = %q", written)
+	}
+
+	// A second write to the same snapshot must not replace the first
+	if _, err := layout.write(device, record, []string{formatText}); err == nil || !strings.Contains(err.Error(), "refusing to overwrite") {
+		t.Errorf("second write: err = %v", err)
+	}
+}
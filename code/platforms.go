@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/scrapli/scrapligo/driver/generic"
+	"github.com/scrapli/scrapligo/driver/options"
+)
+
+// platformAuto asks the collector to detect the platform when it connects
+const platformAuto = "auto"
+
+// platformAliases maps the short names accepted in the inventory onto scrapligo
+// platform names. Every scrapligo name is also accepted as-is.
+var platformAliases = map[string]string{
+	"junos":        "juniper_junos",
+	"iosxe":        "cisco_iosxe",
+	"ios":          "cisco_iosxe",
+	"iosxr":        "cisco_iosxr",
+	"nxos":         "cisco_nxos",
+	"eos":          "arista_eos",
+	"sros":         "nokia_sros",
+	"sros_classic": "nokia_sros_classic",
+	"srl":          "nokia_srl",
+	"panos":        "paloalto_panos",
+	"vyos":         "vyatta_vyos",
+	"ocnos":        "ipinfusion_ocnos",
+}
+
+// scrapliPlatforms lists the platform definitions bundled with scrapligo
+var scrapliPlatforms = []string{
+	"arista_eos",
+	"cisco_iosxe",
+	"cisco_iosxr",
+	"cisco_nxos",
+	"cumulus_linux",
+	"cumulus_vtysh",
+	"ipinfusion_ocnos",
+	"juniper_junos",
+	"nokia_srl",
+	"nokia_sros",
+	"nokia_sros_classic",
+	"paloalto_panos",
+	"vyatta_vyos",
+}
+
+// resolvePlatform returns the scrapligo platform name for an inventory value
+func resolvePlatform(name string) (string, bool) {
+
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == platformAuto {
+		return platformAuto, true
+	}
+	if canonical, ok := platformAliases[name]; ok {
+		return canonical, true
+	}
+	for _, platform := range scrapliPlatforms {
+		if platform == name {
+			return platform, true
+		}
+	}
+	return "", false
+}
+
+// knownPlatformNames lists every accepted platform value, for error messages
+func knownPlatformNames() string {
+
+	names := append([]string{platformAuto}, scrapliPlatforms...)
+	for alias := range platformAliases {
+		names = append(names, alias)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// versionBanners match "show version" output to a platform. Order matters:
+// IOS-XR and NX-OS also mention Cisco IOS so they are checked before IOS-XE.
+var versionBanners = []struct {
+	platform string
+	pattern  *regexp.Regexp
+}{
+	{"juniper_junos", regexp.MustCompile(`(?i)\bjunos\b`)},
+	{"cisco_iosxr", regexp.MustCompile(`(?i)cisco ios xr`)},
+	{"cisco_nxos", regexp.MustCompile(`(?i)nx-os|cisco nexus`)},
+	{"cisco_iosxe", regexp.MustCompile(`(?i)cisco ios[ -]xe|cisco ios software`)},
+	{"arista_eos", regexp.MustCompile(`(?i)arista`)},
+	{"nokia_srl", regexp.MustCompile(`(?i)sr ?linux`)},
+	{"nokia_sros", regexp.MustCompile(`(?i)timos`)},
+	{"vyatta_vyos", regexp.MustCompile(`(?i)vyos`)},
+	{"paloalto_panos", regexp.MustCompile(`(?i)sw-version:`)},
+}
+
+// platformFromBanner picks the platform whose banner matches the output
+func platformFromBanner(output string) (string, bool) {
+	for _, banner := range versionBanners {
+		if banner.pattern.MatchString(output) {
+			return banner.platform, true
+		}
+	}
+	return "", false
+}
+
+// pagingOffCommands turn off the pager on every platform a banner can be
+// detected for, since a "--More--" would hold the probe until its command
+// timeout. Each is sent blind: the platforms that don't know a command just
+// answer it with an error.
+var pagingOffCommands = []string{
+	"terminal length 0",       // IOS-XE, IOS-XR, NX-OS and EOS
+	"set cli screen-length 0", // Junos
+	"environment no more",     // SR OS
+	"set cli pager off",       // PAN-OS
+	"set terminal length 0",   // VyOS
+}
+
+// probeDriver is the vendor neutral session platform detection runs on
+type probeDriver struct {
+	d *generic.Driver
+}
+
+func (p probeDriver) run(cmd string) (commandReply, error) {
+
+	r, err := p.d.SendCommand(cmd)
+	if err != nil {
+		return commandReply{}, err
+	}
+	return commandReply{Output: r.Result, Elapsed: r.ElapsedTime}, nil
+}
+
+func (p probeDriver) close() { p.d.Close() }
+
+func (p probeDriver) abort() { p.d.Channel.Close() }
+
+// openProbe logs in with the generic driver and turns paging off, which is
+// what a platform's own driver would do when it opens
+func openProbe(device Device, creds credentials, settings connectSettings) (deviceDriver, error) {
+
+	opts := append(connectionOptions(device, creds, settings), options.WithOnOpen(func(d *generic.Driver) error {
+		for _, cmd := range pagingOffCommands {
+			if _, err := d.SendCommand(cmd); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	d, err := generic.NewDriver(device.Hostname, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create probe driver %w", err)
+	}
+
+	err = d.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open probe driver %w", settings.HostKeys.explain(device, err))
+	}
+	return probeDriver{d}, nil
+}
+
+// detectPlatform logs in with a vendor neutral driver, runs "show version" and
+// matches the reply against the known version banners. The probe is kept in
+// session so a device deadline can abort it.
+func detectPlatform(session *deviceSession, device Device, creds credentials, settings connectSettings) (string, error) {
+
+	d, err := openProbe(device, creds, settings)
+	if err != nil {
+		return "", err
+	}
+	if err := session.set(d); err != nil {
+		return "", err
+	}
+
+	defer session.close()
+
+	reply, err := d.run("show version")
+	if err != nil {
+		return "", fmt.Errorf("failed to send version probe %w", err)
+	}
+
+	platform, ok := platformFromBanner(reply.Output)
+	if !ok {
+		return "", fmt.Errorf("could not detect platform from show version output")
+	}
+	return platform, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestResolvePlatform(t *testing.T) {
+	cases := map[string]string{
+		"junos":         "juniper_junos",
+		"Juniper_Junos": "juniper_junos",
+		"eos":           "arista_eos",
+		"nokia_sros":    "nokia_sros",
+		"auto":          platformAuto,
+	}
+	for name, want := range cases {
+		if got, ok := resolvePlatform(name); !ok || got != want {
+			t.Errorf("resolvePlatform(%q) = %q, %v, want %q", name, got, ok, want)
+		}
+	}
+	if _, ok := resolvePlatform("ios_classic"); ok {
+		t.Error("resolvePlatform accepted an unknown platform")
+	}
+}
+
+func TestPlatformFromBanner(t *testing.T) {
+	cases := map[string]string{
+		"Hostname: mx1\nModel: mx480\nJunos: 21.4R3-S2.3":                          "juniper_junos",
+		"Cisco IOS XE Software, Version 17.03.04a\nCisco IOS Software [Amsterdam]": "cisco_iosxe",
+		"Cisco IOS XR Software, Version 7.5.2":                                     "cisco_iosxr",
+		"Cisco Nexus Operating System (NX-OS) Software":                            "cisco_nxos",
+		"Arista DCS-7050SX3-48YC8\nSoftware image version: 4.28.3M":                "arista_eos",
+		"TiMOS-C-20.10.R3 cpm/x86_64 Nokia 7750 SR Copyright (c) 2000-2021 Nokia.": "nokia_sros",
+	}
+	for output, want := range cases {
+		if got, ok := platformFromBanner(output); !ok || got != want {
+			t.Errorf("platformFromBanner(%q) = %q, want %q", output, got, want)
+		}
+	}
+	if _, ok := platformFromBanner("% Invalid input detected"); ok {
+		t.Error("platformFromBanner matched unrelated output")
+	}
+}
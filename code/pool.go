@@ -0,0 +1,74 @@
+package main
+
+import (
+	"sync"
+)
+
+// deviceResult holds the outcome of collecting from a single device
+type deviceResult struct {
+	Host   string
+	Output string
+	Err    error
+}
+
+// collectFunc connects to a single device and returns the collected output
+type collectFunc func(device Device) (string, error)
+
+// runWorkerPool fans collect out across devices using at most workers goroutines.
+// Each finished result is handed to report one at a time, so callers can print
+// progress without interleaving. Results are returned in the same order as devices.
+func runWorkerPool(devices []Device, workers int, collect collectFunc, report func(deviceResult)) []deviceResult {
+
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(devices) {
+		workers = len(devices)
+	}
+
+	type job struct {
+		index  int
+		device Device
+	}
+	type done struct {
+		index  int
+		result deviceResult
+	}
+
+	jobs := make(chan job)
+	finished := make(chan done)
+
+	// Start the workers, each pulling hosts off the jobs channel until it is closed
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				output, err := collect(j.device)
+				finished <- done{j.index, deviceResult{Host: j.device.Hostname, Output: output, Err: err}}
+			}
+		}()
+	}
+
+	// Feed the devices in and close the results channel once every worker has exited
+	go func() {
+		for i, device := range devices {
+			jobs <- job{i, device}
+		}
+		close(jobs)
+		wg.Wait()
+		close(finished)
+	}()
+
+	// Aggregate on this goroutine only so report is never called concurrently
+	results := make([]deviceResult, len(devices))
+	for d := range finished {
+		results[d.index] = d.result
+		if report != nil {
+			report(d.result)
+		}
+	}
+
+	return results
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/scrapli/scrapligo/driver/opoptions"
+	"github.com/scrapli/scrapligo/response"
+	"github.com/scrapli/scrapligo/util"
+)
+
+// pushPrivilege is the scrapligo privilege level changes are loaded in. An
+// exclusive configuration session keeps other users' edits out of the commit
+// and throws the change away if the session drops before it's committed.
+const pushPrivilege = "configuration-exclusive"
+
+// Where a push left a device
+const (
+	// pushUnchanged means the change was already in place, nothing was committed
+	pushUnchanged = "unchanged"
+	// pushDiscarded means the change failed to load or commit and was thrown away
+	pushDiscarded = "discarded"
+	// pushPending means a commit confirmed went in but was never confirmed, so
+	// the device rolls it back by itself once the confirm timer runs out
+	pushPending = "pending"
+	// pushConfirmed means the change passed its checks and is committed for good
+	pushConfirmed = "confirmed"
+	// pushRolledBack means the change failed its checks and was rolled back
+	pushRolledBack = "rolled back"
+)
+
+// configSession is the part of the network driver a push uses
+type configSession interface {
+	SendConfigs(configs []string, opts ...util.Option) (*response.MultiResponse, error)
+	SendCommand(command string, opts ...util.Option) (*response.Response, error)
+}
+
+// postCheck is a command run once the change is committed. The check fails if
+// the device rejects the command or, when Expect is set, the output doesn't
+// match it.
+type postCheck struct {
+	Command string
+	Expect  *regexp.Regexp
+}
+
+// pushPlan is the change pushed to every device
+type pushPlan struct {
+	// Config holds the set commands making up the change
+	Config []string
+	// ConfirmMinutes is how long the device waits for the confirming commit
+	// before rolling the change back by itself
+	ConfirmMinutes int
+	Checks         []postCheck
+}
+
+// pushOutcome is what a push did to one device
+type pushOutcome struct {
+	State string
+	// Diff is the "show | compare" output for the loaded change
+	Diff string
+}
+
+// loadPostChecks reads a post-check file. Each line is a command, optionally
+// followed by "=>" and a regular expression its output must match, e.g.
+// "show bgp summary | match Down => Down peers: 0".
+func loadPostChecks(file string) ([]postCheck, error) {
+
+	checks := []postCheck{}
+	for _, line := range fileToSlice(file) {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		command, expect, found := strings.Cut(line, "=>")
+		check := postCheck{Command: strings.TrimSpace(command)}
+		if check.Command == "" {
+			return nil, fmt.Errorf("%s: %q has no command", file, line)
+		}
+		if found {
+			pattern, err := regexp.Compile(strings.TrimSpace(expect))
+			if err != nil {
+				return nil, fmt.Errorf("%s: check %q: %w", file, check.Command, err)
+			}
+			check.Expect = pattern
+		}
+		checks = append(checks, check)
+	}
+	return checks, nil
+}
+
+// run reports why the check failed, or nil if it passed
+func (c postCheck) run(s configSession) error {
+
+	r, err := s.SendCommand(c.Command)
+	if err != nil {
+		return fmt.Errorf("check %q: %w", c.Command, err)
+	}
+	if rejected := junosRejection(r); rejected != nil {
+		return fmt.Errorf("check %q: %w", c.Command, rejected)
+	}
+	if c.Expect != nil && !c.Expect.MatchString(r.Result) {
+		return fmt.Errorf("check %q: output does not match %q", c.Command, c.Expect)
+	}
+	return nil
+}
+
+// junosRejection returns the error in a Junos response, if any
+func junosRejection(r *response.Response) error {
+
+	if rejected := classifyCLIError("juniper_junos", r.Result); rejected != nil {
+		return rejected
+	}
+	if r.Failed != nil {
+		return fmt.Errorf("%w: %v", errCommandRejected, r.Failed)
+	}
+	return nil
+}
+
+// sendConfig sends lines in the push's configuration session, stopping at the
+// first one the device rejects, and returns the output of the last one sent
+func sendConfig(s configSession, lines ...string) (string, error) {
+
+	output := ""
+	for _, line := range lines {
+		m, err := s.SendConfigs([]string{line}, opoptions.WithPrivilegeLevel(pushPrivilege))
+		if err != nil {
+			return output, fmt.Errorf("%q: %w", line, err)
+		}
+		r := m.Responses[0]
+		output = r.Result
+		if rejected := junosRejection(r); rejected != nil {
+			return output, fmt.Errorf("%q: %w", line, rejected)
+		}
+	}
+	return output, nil
+}
+
+// commit sends lines ending in a commit, which only counts once the device
+// says it's complete
+func commit(s configSession, lines ...string) error {
+
+	output, err := sendConfig(s, lines...)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(output, "commit complete") {
+		return fmt.Errorf("%q: no \"commit complete\" in the output: %s", lines[len(lines)-1], strings.TrimSpace(output))
+	}
+	return nil
+}
+
+// pushChange loads the plan's change into the device, commits it with commit
+// confirmed and runs the post-checks, then confirms the change if they pass or
+// rolls it back if they don't. Nothing is committed if the change is already
+// in place or fails to load.
+func pushChange(s configSession, plan pushPlan) (*pushOutcome, error) {
+
+	outcome := &pushOutcome{}
+
+	// Throw away the loaded candidate, for anything that goes wrong before the commit
+	discard := func(err error) (*pushOutcome, error) {
+		outcome.State = pushDiscarded
+		if _, discardErr := sendConfig(s, "rollback 0"); discardErr != nil {
+			return outcome, fmt.Errorf("%v, then failed to discard the change %w", err, discardErr)
+		}
+		return outcome, err
+	}
+
+	if _, err := sendConfig(s, plan.Config...); err != nil {
+		return discard(fmt.Errorf("failed to load the change %w", err))
+	}
+	diff, err := sendConfig(s, "show | compare")
+	if err != nil {
+		return discard(err)
+	}
+	outcome.Diff = strings.TrimSpace(diff)
+	if outcome.Diff == "" {
+		_, err := discard(nil)
+		outcome.State = pushUnchanged
+		return outcome, err
+	}
+
+	err = commit(s, fmt.Sprintf("commit confirmed %d comment \"configcollector push\"", plan.ConfirmMinutes))
+	if err != nil {
+		return discard(fmt.Errorf("commit confirmed failed %w", err))
+	}
+	outcome.State = pushPending
+
+	for _, check := range plan.Checks {
+		checkErr := check.run(s)
+		if checkErr == nil {
+			continue
+		}
+		if err := commit(s, "rollback 1", "commit"); err != nil {
+			return outcome, fmt.Errorf("%v, then rolling back failed, the device rolls back by itself within %d minutes %w", checkErr, plan.ConfirmMinutes, err)
+		}
+		outcome.State = pushRolledBack
+		return outcome, fmt.Errorf("post-check failed, rolled back: %w", checkErr)
+	}
+
+	if err := commit(s, "commit"); err != nil {
+		return outcome, fmt.Errorf("confirming the commit failed, the device rolls back by itself within %d minutes %w", plan.ConfirmMinutes, err)
+	}
+	outcome.State = pushConfirmed
+	return outcome, nil
+}
+
+func runPush(args []string) (int, error) {
+
+	fs := flag.NewFlagSet("push", flag.ExitOnError)
+	var connect connectFlags
+	connect.register(fs)
+	inventoryFile := fs.String("inventory", "inventory.yaml", "inventory file (.yaml, .csv, or one hostname per line)")
+	configFile := fs.String("config", "", "file of set commands to load, one per line")
+	checksFile := fs.String("checks", "", "file of post-check commands, each optionally followed by => and a regular expression its output must match")
+	confirmMinutes := fs.Int("confirm", 5, "minutes the device waits for the confirming commit before rolling back by itself")
+	var hostList stringList
+	fs.Var(&hostList, "host", "only push to this device, may be repeated")
+	fs.Parse(args)
+
+	if *configFile == "" {
+		return 0, fmt.Errorf("usage: push -config FILE [flags]")
+	}
+	if *confirmMinutes < 1 {
+		return 0, fmt.Errorf("-confirm must be at least 1 minute")
+	}
+	plan := pushPlan{Config: []string{}, ConfirmMinutes: *confirmMinutes, Checks: []postCheck{}}
+	for _, line := range fileToSlice(*configFile) {
+		if !strings.HasPrefix(line, "#") {
+			plan.Config = append(plan.Config, line)
+		}
+	}
+	if len(plan.Config) == 0 {
+		return 0, fmt.Errorf("%s holds no set commands", *configFile)
+	}
+	if *checksFile != "" {
+		checks, err := loadPostChecks(*checksFile)
+		if err != nil {
+			return 0, err
+		}
+		plan.Checks = checks
+	}
+
+	inventory, err := loadInventory(*inventoryFile)
+	if err != nil {
+		return 0, err
+	}
+	devices := inventory.Devices
+	if len(hostList) > 0 {
+		wanted := map[string]bool{}
+		for _, host := range hostList {
+			wanted[host] = true
+		}
+		devices = []Device{}
+		for _, device := range inventory.Devices {
+			if wanted[device.Hostname] {
+				devices = append(devices, device)
+				delete(wanted, device.Hostname)
+			}
+		}
+		for host := range wanted {
+			return 0, fmt.Errorf("%s is not in %s", host, *inventoryFile)
+		}
+	}
+
+	deviceCreds, credentialErrs, err := connect.prepare(devices)
+	if err != nil {
+		return 0, err
+	}
+	settings := connect.Settings
+	if settings.JumpConfig != "" {
+		defer os.Remove(settings.JumpConfig)
+	}
+
+	var outcomesMu sync.Mutex
+	outcomes := map[string]*pushOutcome{}
+
+	push := func(device Device) (string, error) {
+		if err := credentialErrs[device.Hostname]; err != nil {
+			return "", err
+		}
+		if device.Platform != "juniper_junos" || device.Transport == transportNetconf {
+			return "", fmt.Errorf("push only supports Junos devices over ssh, not %s over %s", device.Platform, device.Transport)
+		}
+
+		ctx, cancel := settings.Limits.deviceContext(context.Background())
+		defer cancel()
+		session := &deviceSession{}
+		err := runWithDeadline(ctx, func() error {
+			// Only opening the session is retried, a change is never sent twice
+			var d cliDriver
+			err := settings.Retry.do(ctx, "open", func() error {
+				opened, err := openDriver(device, deviceCreds[device.Hostname], settings)
+				if err != nil {
+					return err
+				}
+				d = cliDriver{opened, device.Platform}
+				return session.set(d)
+			})
+			if err != nil {
+				return err
+			}
+			defer session.close()
+
+			outcome, err := pushChange(d.d, plan)
+			outcomesMu.Lock()
+			outcomes[device.Hostname] = outcome
+			outcomesMu.Unlock()
+			return err
+		}, session.abort)
+		return "", err
+	}
+
+	report := func(result deviceResult) {
+		outcomesMu.Lock()
+		outcome := outcomes[result.Host]
+		outcomesMu.Unlock()
+		if outcome != nil && outcome.Diff != "" {
+			fmt.Printf("%s: show | compare\n%s\n", result.Host, outcome.Diff)
+		}
+		state := "not changed"
+		switch {
+		case outcome != nil:
+			state = outcome.State
+		case failureClass(result.Err) == classTimeout:
+			// The push may have been anywhere, even past the commit confirmed
+			state = fmt.Sprintf("state unknown, any unconfirmed commit rolls back within %d minutes", plan.ConfirmMinutes)
+		}
+		if result.Err != nil {
+			fmt.Printf("%s: %s, Error: %v\n", result.Host, state, result.Err)
+		} else {
+			fmt.Printf("%s: %s\n", result.Host, state)
+		}
+	}
+
+	results := runWorkerPool(devices, connect.Workers, push, report)
+	return printSummary("Pushed to", results), nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/scrapli/scrapligo/response"
+	"github.com/scrapli/scrapligo/util"
+)
+
+// fakeJunos answers a push the way a Junos device would
+type fakeJunos struct {
+	// diff is what "show | compare" shows once the change is loaded
+	diff string
+	// replies overrides the output for a line
+	replies map[string]string
+	sent    []string
+}
+
+func (f *fakeJunos) reply(line string) *response.Response {
+
+	f.sent = append(f.sent, line)
+	output, ok := f.replies[line]
+	switch {
+	case ok:
+	case line == "show | compare":
+		output = f.diff
+	case strings.HasPrefix(line, "commit"):
+		output = "commit complete"
+	case strings.HasPrefix(line, "rollback"):
+		output = "load complete"
+	}
+	r := response.NewResponse(line, "mx1", 22, nil)
+	r.Record([]byte(output))
+	return r
+}
+
+func (f *fakeJunos) SendConfigs(configs []string, opts ...util.Option) (*response.MultiResponse, error) {
+	m := response.NewMultiResponse("mx1")
+	for _, line := range configs {
+		m.AppendResponse(f.reply(line))
+	}
+	return m, nil
+}
+
+func (f *fakeJunos) SendCommand(command string, opts ...util.Option) (*response.Response, error) {
+	return f.reply(command), nil
+}
+
+const pushCommit = `commit confirmed 5 comment "configcollector push"`
+
+func TestPushChange(t *testing.T) {
+	change := []string{"set system ntp server 10.0.0.10", "delete system ntp server 10.0.0.9"}
+	diff := "[edit system ntp]\n+    server 10.0.0.10;\n-    server 10.0.0.9;"
+	checks := []postCheck{{Command: "show ntp associations"}}
+
+	cases := []struct {
+		name    string
+		diff    string
+		replies map[string]string
+		state   string
+		err     string
+		sent    []string
+	}{
+		{
+			name:  "confirmed",
+			diff:  diff,
+			state: pushConfirmed,
+			sent:  append(append([]string{}, change...), "show | compare", pushCommit, "show ntp associations", "commit"),
+		},
+		{
+			name:    "check fails",
+			diff:    diff,
+			replies: map[string]string{"show ntp associations": "error: the ntp subsystem is not responding"},
+			state:   pushRolledBack,
+			err:     "post-check failed, rolled back",
+			sent:    append(append([]string{}, change...), "show | compare", pushCommit, "show ntp associations", "rollback 1", "commit"),
+		},
+		{
+			name:    "load fails",
+			replies: map[string]string{change[1]: "                      ^\nsyntax error."},
+			state:   pushDiscarded,
+			err:     "failed to load the change",
+			sent:    append(append([]string{}, change...), "rollback 0"),
+		},
+		{
+			name:    "commit fails",
+			diff:    diff,
+			replies: map[string]string{pushCommit: "error: configuration check-out failed"},
+			state:   pushDiscarded,
+			err:     "commit confirmed failed",
+			sent:    append(append([]string{}, change...), "show | compare", pushCommit, "rollback 0"),
+		},
+		{
+			name:  "already in place",
+			state: pushUnchanged,
+			sent:  append(append([]string{}, change...), "show | compare", "rollback 0"),
+		},
+	}
+	for _, c := range cases {
+		device := &fakeJunos{diff: c.diff, replies: c.replies}
+		outcome, err := pushChange(device, pushPlan{Config: change, ConfirmMinutes: 5, Checks: checks})
+		switch {
+		case c.err == "" && err != nil:
+			t.Errorf("%s: %v", c.name, err)
+		case c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)):
+			t.Errorf("%s: err = %v, want %q", c.name, err, c.err)
+		}
+		if outcome.State != c.state {
+			t.Errorf("%s: state = %s, want %s", c.name, outcome.State, c.state)
+		}
+		if !reflect.DeepEqual(device.sent, c.sent) {
+			t.Errorf("%s: sent\n  %q\nwant\n  %q", c.name, device.sent, c.sent)
+		}
+	}
+}
+
+func TestLoadPostChecks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checks.txt")
+	content := "# checks\nshow system alarms => No alarms currently active\nshow bgp summary | match Establ\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	checks, err := loadPostChecks(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checks) != 2 || checks[0].Command != "show system alarms" || checks[0].Expect.String() != "No alarms currently active" || checks[1].Expect != nil {
+		t.Errorf("checks = %+v", checks)
+	}
+
+	device := &fakeJunos{replies: map[string]string{"show system alarms": "1 alarms currently active"}}
+	if err := checks[0].run(device); err == nil {
+		t.Error("want an error when the output doesn't match")
+	}
+
+	if err := os.WriteFile(path, []byte("show version => ([\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadPostChecks(path); err == nil {
+		t.Error("want an error for a bad pattern")
+	}
+}
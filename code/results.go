@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Output formats a device's results can be written in
+const (
+	formatText = "text"
+	formatJSON = "json"
+	formatYAML = "yaml"
+)
+
+// formatExtensions maps each output format to its file extension
+var formatExtensions = map[string]string{
+	formatText: ".txt",
+	formatJSON: ".json",
+	formatYAML: ".yaml",
+}
+
+// commandResult is the outcome of one command sent to a device
+type commandResult struct {
+	Command string `json:"command" yaml:"command"`
+	Output  string `json:"output" yaml:"output"`
+	Error   string `json:"error,omitempty" yaml:"error,omitempty"`
+	// Parsed holds the rows a TextFSM template extracted from the output
+	Parsed     []map[string]interface{} `json:"parsed,omitempty" yaml:"parsed,omitempty"`
+	ParseError string                   `json:"parse_error,omitempty" yaml:"parse_error,omitempty"`
+	Start      time.Time                `json:"start" yaml:"start"`
+	End        time.Time                `json:"end" yaml:"end"`
+	// Duration is in seconds, including any retries
+	Duration float64 `json:"duration" yaml:"duration"`
+	// Elapsed is the seconds the driver reported for the last attempt alone
+	Elapsed float64 `json:"elapsed,omitempty" yaml:"elapsed,omitempty"`
+	// Rejected is set when the device answered the command with an error
+	Rejected bool `json:"rejected,omitempty" yaml:"rejected,omitempty"`
+	// ErrorClass sorts a rejected CLI command, e.g. syntax or permission
+	ErrorClass string `json:"error_class,omitempty" yaml:"error_class,omitempty"`
+}
+
+// deviceRecord is everything collected from one device in a run
+type deviceRecord struct {
+	RunID    string `json:"run_id" yaml:"run_id"`
+	Hostname string `json:"hostname" yaml:"hostname"`
+	Platform string `json:"platform" yaml:"platform"`
+	// Transport is how the commands were sent, ssh or netconf
+	Transport string          `json:"transport,omitempty" yaml:"transport,omitempty"`
+	Start     time.Time       `json:"start" yaml:"start"`
+	End       time.Time       `json:"end" yaml:"end"`
+	Duration  float64         `json:"duration" yaml:"duration"`
+	Commands  []commandResult `json:"commands" yaml:"commands"`
+	// Facts is only set when facts were gathered from the device
+	Facts *deviceFacts `json:"facts,omitempty" yaml:"facts,omitempty"`
+	// Error is why collection stopped early or which commands failed
+	Error string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+func newDeviceRecord(device Device, start time.Time) *deviceRecord {
+	return &deviceRecord{
+		Hostname:  device.Hostname,
+		Platform:  device.Platform,
+		Transport: device.Transport,
+		Start:     start.UTC(),
+		Commands:  []commandResult{},
+	}
+}
+
+// addCommand records the result of a command that ran from start until now
+func (r *deviceRecord) addCommand(command string, output string, err error, start time.Time) {
+
+	end := time.Now()
+	result := commandResult{
+		Command:  command,
+		Output:   output,
+		Start:    start.UTC(),
+		End:      end.UTC(),
+		Duration: end.Sub(start).Seconds(),
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	r.Commands = append(r.Commands, result)
+}
+
+// addReply records a command's reply along with the timing the driver measured
+func (r *deviceRecord) addReply(command string, reply commandReply, err error, start time.Time) {
+
+	r.addCommand(command, reply.Output, err, start)
+	result := &r.Commands[len(r.Commands)-1]
+	result.Elapsed = reply.Elapsed
+	result.Rejected = errors.Is(err, errCommandRejected)
+	var rejected *cliError
+	if errors.As(err, &rejected) {
+		result.ErrorClass = rejected.Class
+	}
+}
+
+// finish stamps the end time and the error, if any, the device finished with
+func (r *deviceRecord) finish(err error) {
+
+	r.End = time.Now().UTC()
+	r.Duration = r.End.Sub(r.Start).Seconds()
+	if err != nil {
+		r.Error = err.Error()
+	}
+}
+
+// text renders the record in the collector's original plain text layout
+func (r *deviceRecord) text() string {
+
+	var all_output strings.Builder
+	for _, result := range r.Commands {
+		all_output.WriteString(result.Command + "\n")
+		all_output.WriteString("-----------------------------------\n")
+		if result.Error != "" {
+			all_output.WriteString("ERROR: " + result.Error + "\n")
+			// What the device said when it refused the command is worth keeping
+			if result.Rejected && result.Output != "" {
+				all_output.WriteString(result.Output + "\n")
+			}
+		} else {
+			all_output.WriteString(result.Output + "\n")
+		}
+		all_output.WriteString("-------------------------------------------------------------------\n")
+	}
+	return all_output.String()
+}
+
+// encode serialises the record in one of the output formats
+func (r *deviceRecord) encode(format string) ([]byte, error) {
+
+	if format == formatText {
+		return []byte(r.text()), nil
+	}
+	return encodeValue(r, format)
+}
+
+// encodeValue serialises v as JSON or YAML
+func encodeValue(v interface{}, format string) ([]byte, error) {
+
+	switch format {
+	case formatJSON:
+		content, err := json.MarshalIndent(v, "", "  ")
+		return append(content, '\n'), err
+	case formatYAML:
+		return yaml.Marshal(v)
+	}
+	return nil, fmt.Errorf("unknown output format %q", format)
+}
+
+// parseOutputFormats turns a comma separated flag value into a list of formats
+func parseOutputFormats(value string) ([]string, error) {
+
+	formats := []string{}
+	for _, format := range strings.Split(value, ",") {
+		format = strings.TrimSpace(format)
+		if format == "" {
+			continue
+		}
+		if _, ok := formatExtensions[format]; !ok {
+			return nil, fmt.Errorf("unknown output format %q (expected %s, %s or %s)", format, formatText, formatJSON, formatYAML)
+		}
+		formats = append(formats, format)
+	}
+	if len(formats) == 0 {
+		return nil, fmt.Errorf("no output format given")
+	}
+	return formats, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func testRecord() *deviceRecord {
+	record := newDeviceRecord(Device{Hostname: "mx1", Platform: "juniper_junos"}, time.Now())
+	record.addCommand("show version", "Junos: 21.4R3", nil, time.Now())
+	record.addCommand("show bogus", "", errors.New("syntax error"), time.Now())
+	record.finish(errors.New("1 of 2 commands failed"))
+	return record
+}
+
+func TestDeviceRecordText(t *testing.T) {
+	got := testRecord().text()
+	for _, want := range []string{
+		"show version\n-----------------------------------\nJunos: 21.4R3\n",
+		"show bogus\n-----------------------------------\nERROR: syntax error\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("text() is missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestDeviceRecordAddReply(t *testing.T) {
+	record := newDeviceRecord(Device{Hostname: "mx1", Platform: "juniper_junos"}, time.Now())
+	record.addReply("show version", commandReply{Output: "Junos: 21.4R3", Elapsed: 0.25}, nil, time.Now())
+	rejected := fmt.Errorf("%w: output contains %q", errCommandRejected, "syntax error")
+	record.addReply("show bogus", commandReply{Output: "syntax error, expecting <command>."}, rejected, time.Now())
+
+	if got := record.Commands[0]; got.Elapsed != 0.25 || got.Rejected || got.Error != "" {
+		t.Errorf("show version = %+v", got)
+	}
+	if got := record.Commands[1]; !got.Rejected || got.Error == "" {
+		t.Errorf("show bogus = %+v", got)
+	}
+	if text := record.text(); !strings.Contains(text, "ERROR: device rejected the command: output contains \"syntax error\"\nsyntax error, expecting <command>.\n") {
+		t.Errorf("text() does not show the rejected command's output:\n%s", text)
+	}
+}
+
+func TestDeviceRecordEncode(t *testing.T) {
+	record := testRecord()
+
+	for _, format := range []string{formatJSON, formatYAML} {
+		content, err := record.encode(format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded deviceRecord
+		if format == formatJSON {
+			err = json.Unmarshal(content, &decoded)
+		} else {
+			err = yaml.Unmarshal(content, &decoded)
+		}
+		if err != nil {
+			t.Fatalf("%s: %v\n%s", format, err, content)
+		}
+		if decoded.Hostname != "mx1" || decoded.Platform != "juniper_junos" || len(decoded.Commands) != 2 {
+			t.Errorf("%s: decoded = %+v", format, decoded)
+		}
+		if decoded.Commands[1].Error != "syntax error" || decoded.Error == "" {
+			t.Errorf("%s: errors were not kept: %+v", format, decoded)
+		}
+		if decoded.End.Before(decoded.Start) {
+			t.Errorf("%s: end %v is before start %v", format, decoded.End, decoded.Start)
+		}
+	}
+}
+
+func TestParseOutputFormats(t *testing.T) {
+	formats, err := parseOutputFormats("text, json,yaml")
+	if err != nil || strings.Join(formats, ",") != "text,json,yaml" {
+		t.Errorf("parseOutputFormats() = %v, %v", formats, err)
+	}
+	if _, err := parseOutputFormats("xml"); err == nil {
+		t.Error("want an error for an unknown format")
+	}
+	if _, err := parseOutputFormats(""); err == nil {
+		t.Error("want an error for no formats")
+	}
+}
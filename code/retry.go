@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/scrapli/scrapligo/util"
+)
+
+// Error classes used to decide whether a failed attempt is worth retrying
+const (
+	errorClassAuth       = "auth"
+	errorClassConnection = "connection"
+	errorClassTimeout    = "timeout"
+	errorClassOther      = "other"
+)
+
+// retryPolicy describes how often and how patiently a failing phase is retried
+type retryPolicy struct {
+	// MaxAttempts is the total number of tries, 1 disables retrying
+	MaxAttempts int
+	// BaseDelay is the wait before the second attempt, doubling after each failure
+	BaseDelay time.Duration
+	// MaxDelay caps the wait between attempts
+	MaxDelay time.Duration
+	// Jitter randomises each wait by up to this fraction in either direction
+	Jitter float64
+	// RetryOn lists the error classes that may be retried, auth is never retried
+	RetryOn []string
+}
+
+// parseRetryClasses turns a comma separated flag value into a list of error classes
+func parseRetryClasses(value string) ([]string, error) {
+
+	classes := []string{}
+	for _, class := range strings.Split(value, ",") {
+		class = strings.TrimSpace(class)
+		switch class {
+		case "":
+			continue
+		case errorClassConnection, errorClassTimeout, errorClassOther:
+			classes = append(classes, class)
+		case errorClassAuth:
+			return nil, fmt.Errorf("auth failures are never retried")
+		default:
+			return nil, fmt.Errorf("unknown retry error class %q", class)
+		}
+	}
+	return classes, nil
+}
+
+// errorClass sorts an error from scrapligo into one of the retry classes
+func errorClass(err error) string {
+
+	message := strings.ToLower(err.Error())
+	switch {
+	// The device's own words, e.g. "permission denied" for a command, say
+	// nothing about the session
+	case errors.Is(err, errCommandRejected):
+		return errorClassOther
+	case errors.Is(err, util.ErrAuthError),
+		errors.Is(err, errHostKeyChanged),
+		errors.Is(err, errHostKeyUnknown),
+		strings.Contains(message, "permission denied"),
+		strings.Contains(message, "host key verification failed"):
+		return errorClassAuth
+	case failureClass(err) == classTimeout:
+		return errorClassTimeout
+	case errors.Is(err, util.ErrConnectionError),
+		strings.Contains(message, "connection refused"),
+		strings.Contains(message, "connection reset"),
+		strings.Contains(message, "broken pipe"),
+		strings.Contains(message, "eof"):
+		return errorClassConnection
+	}
+	return errorClassOther
+}
+
+// retryable reports whether err may be tried again under this policy
+func (r retryPolicy) retryable(err error) bool {
+
+	// Device wall time is spent, another attempt could never finish
+	if errors.Is(err, errDeviceTimeout) {
+		return false
+	}
+	// The device answered and said no, asking again gets the same answer
+	if errors.Is(err, errCommandRejected) {
+		return false
+	}
+
+	class := errorClass(err)
+	if class == errorClassAuth {
+		return false
+	}
+	for _, allowed := range r.RetryOn {
+		if allowed == class {
+			return true
+		}
+	}
+	return false
+}
+
+// delay returns the wait before the given attempt number (2 for the first retry)
+func (r retryPolicy) delay(attempt int) time.Duration {
+
+	wait := r.BaseDelay
+	for i := 2; i < attempt; i++ {
+		wait *= 2
+		if r.MaxDelay > 0 && wait >= r.MaxDelay {
+			break
+		}
+	}
+	if r.MaxDelay > 0 && wait > r.MaxDelay {
+		wait = r.MaxDelay
+	}
+
+	if r.Jitter > 0 {
+		// Spread the wait over [1-jitter, 1+jitter] so flapping devices don't retry in lockstep
+		wait = time.Duration(float64(wait) * (1 + r.Jitter*(2*rand.Float64()-1)))
+	}
+	return wait
+}
+
+// do runs fn until it succeeds, fails with a non-retryable error, runs out of
+// attempts or ctx expires. The returned error names the phase and attempt count.
+func (r retryPolicy) do(ctx context.Context, phase string, fn func() error) error {
+
+	attempts := r.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-time.After(r.delay(attempt)):
+			case <-ctx.Done():
+				return fmt.Errorf("%w: %s: %v (last error: %v)", errDeviceTimeout, phase, ctx.Err(), err)
+			}
+		}
+
+		err = fn()
+		if err == nil {
+			return nil
+		}
+		if !r.retryable(err) {
+			break
+		}
+		if attempt == attempts && attempts > 1 {
+			return fmt.Errorf("%s failed after %d attempts: %w", phase, attempts, err)
+		}
+	}
+	return err
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/scrapli/scrapligo/util"
+)
+
+func TestRetryPolicyRetriesTransientErrors(t *testing.T) {
+	policy := retryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, RetryOn: []string{errorClassConnection}}
+
+	calls := 0
+	err := policy.do(context.Background(), "open", func() error {
+		calls++
+		if calls < 3 {
+			return fmt.Errorf("%w: connection refused", util.ErrConnectionError)
+		}
+		return nil
+	})
+
+	if err != nil {
+		t.Errorf("err = %v, want nil", err)
+	}
+	if calls != 3 {
+		t.Errorf("calls = %d, want 3", calls)
+	}
+}
+
+func TestRetryPolicyNeverRetriesAuth(t *testing.T) {
+	policy := retryPolicy{MaxAttempts: 5, BaseDelay: time.Millisecond,
+		RetryOn: []string{errorClassConnection, errorClassTimeout, errorClassOther}}
+
+	calls := 0
+	err := policy.do(context.Background(), "open", func() error {
+		calls++
+		return fmt.Errorf("%w: password prompt seen multiple times", util.ErrAuthError)
+	})
+
+	if !errors.Is(err, util.ErrAuthError) {
+		t.Errorf("err = %v, want auth error", err)
+	}
+	if calls != 1 {
+		t.Errorf("calls = %d, want 1", calls)
+	}
+}
+
+func TestRetryPolicyNeverRetriesRejectedCommands(t *testing.T) {
+	policy := retryPolicy{MaxAttempts: 5, BaseDelay: time.Millisecond, RetryOn: []string{errorClassOther}}
+
+	calls := 0
+	err := policy.do(context.Background(), "command", func() error {
+		calls++
+		return fmt.Errorf("%w: output contains %q", errCommandRejected, "unknown command")
+	})
+
+	if !errors.Is(err, errCommandRejected) || calls != 1 {
+		t.Errorf("err = %v after %d calls, want one rejected call", err, calls)
+	}
+}
+
+func TestRetryPolicyGivesUp(t *testing.T) {
+	policy := retryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, RetryOn: []string{errorClassTimeout}}
+
+	calls := 0
+	err := policy.do(context.Background(), "command", func() error {
+		calls++
+		return util.ErrTimeoutError
+	})
+
+	if !errors.Is(err, util.ErrTimeoutError) || calls != 2 {
+		t.Errorf("err = %v after %d calls, want timeout after 2", err, calls)
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := retryPolicy{BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		if got := policy.delay(i + 2); got != w {
+			t.Errorf("delay(%d) = %v, want %v", i+2, got, w)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := policy.delay(2); got < 500*time.Millisecond || got > 1500*time.Millisecond {
+			t.Fatalf("jittered delay %v outside [0.5s, 1.5s]", got)
+		}
+	}
+}
+
+func TestParseRetryClasses(t *testing.T) {
+	classes, err := parseRetryClasses("connection, timeout")
+	if err != nil || len(classes) != 2 {
+		t.Errorf("parseRetryClasses() = %v, %v", classes, err)
+	}
+	if _, err := parseRetryClasses("auth"); err == nil {
+		t.Error("expected auth to be rejected")
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// expandHome replaces a leading ~/ with the user's home directory
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[2:])
+}
+
+// sshAuth describes how devices are authenticated. Keys and the agent are
+// offered first; a password is only tried when no key or agent applies to the
+// device or PasswordFallback is set.
+type sshAuth struct {
+	// KeyFile is the default private key, a device's ssh_key overrides it
+	KeyFile string
+	// Passphrases holds passphrases for encrypted key files, keyed by path
+	Passphrases map[string]string
+	// Agent is the ssh-agent socket, empty to keep ssh away from any agent
+	Agent string
+	// PasswordFallback offers the password after keys and agent have failed
+	PasswordFallback bool
+}
+
+// keyFor returns the private key file to use for a device, if any
+func (a sshAuth) keyFor(device Device) string {
+	if device.SSHKey != "" {
+		return device.SSHKey
+	}
+	return a.KeyFile
+}
+
+// usesPassword reports whether a password may be offered to the device
+func (a sshAuth) usesPassword(device Device) bool {
+	return a.PasswordFallback || (a.keyFor(device) == "" && a.Agent == "")
+}
+
+// sshOptions returns the OpenSSH options selecting the device's auth methods
+func (a sshAuth) sshOptions(device Device) []string {
+
+	args := []string{}
+	if key := a.keyFor(device); key != "" {
+		args = append(args, "-i", key)
+	}
+	if a.Agent != "" {
+		args = append(args, "-o", "IdentityAgent="+a.Agent)
+	} else {
+		args = append(args, "-o", "IdentityAgent=none")
+	}
+
+	methods := []string{}
+	if a.keyFor(device) != "" || a.Agent != "" {
+		methods = append(methods, "publickey")
+	}
+	if a.usesPassword(device) {
+		methods = append(methods, "keyboard-interactive", "password")
+	} else {
+		// Fail fast on a key-only device rather than sit at a password prompt
+		args = append(args, "-o", "PasswordAuthentication=no", "-o", "KbdInteractiveAuthentication=no")
+	}
+	return append(args, "-o", "PreferredAuthentications="+strings.Join(methods, ","))
+}
+
+// keyNeedsPassphrase reports whether a private key file is encrypted
+func keyNeedsPassphrase(path string) (bool, error) {
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	_, err = ssh.ParsePrivateKey(content)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", path, err)
+	}
+	return false, nil
+}
+
+// sshOpenArgs builds the full argument list for the ssh binary behind
+// scrapligo's system transport. scrapligo's own defaults are replaced rather
+// than extended because ssh keeps the first value it sees for an option.
+func sshOpenArgs(device Device, username string, settings connectSettings) []string {
+
+	seconds := int(settings.Limits.Connect.Seconds())
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	args := []string{
+		device.Hostname,
+		"-p", fmt.Sprintf("%d", device.Port),
+		"-o", fmt.Sprintf("ConnectTimeout=%d", seconds),
+		"-o", fmt.Sprintf("ServerAliveInterval=%d", seconds),
+	}
+	if len(device.Jump) > 0 {
+		args = append(args, "-F", settings.JumpConfig, "-J", jumpAlias(device.Jump))
+	} else {
+		args = append(args, "-F", "/dev/null")
+	}
+	if username != "" {
+		args = append(args, "-l", username)
+	}
+	args = append(args, settings.HostKeys.sshOptions()...)
+	args = append(args, settings.Auth.sshOptions(device)...)
+
+	return args
+}
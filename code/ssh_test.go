@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSSHOpenArgs(t *testing.T) {
+	settings := connectSettings{
+		Limits:   timeouts{Connect: 10 * time.Second},
+		HostKeys: hostKeyPolicy{Mode: hostKeyStrict, KnownHosts: "/tmp/known_hosts"},
+	}
+	device := Device{Hostname: "mx1", Port: 2222}
+
+	got := sshOpenArgs(device, "netops", settings)
+	want := []string{
+		"mx1", "-p", "2222",
+		"-o", "ConnectTimeout=10", "-o", "ServerAliveInterval=10",
+		"-F", "/dev/null",
+		"-l", "netops",
+		"-o", "StrictHostKeyChecking=yes", "-o", "UserKnownHostsFile=/tmp/known_hosts",
+		"-o", "IdentityAgent=none",
+		"-o", "PreferredAuthentications=keyboard-interactive,password",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sshOpenArgs() =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestSSHAuthOptions(t *testing.T) {
+	cases := []struct {
+		auth   sshAuth
+		device Device
+		want   string
+	}{
+		{sshAuth{KeyFile: "/keys/id_ed25519"}, Device{},
+			"-i /keys/id_ed25519 -o IdentityAgent=none -o PasswordAuthentication=no -o KbdInteractiveAuthentication=no -o PreferredAuthentications=publickey"},
+		{sshAuth{KeyFile: "/keys/id_ed25519"}, Device{SSHKey: "/keys/core"},
+			"-i /keys/core -o IdentityAgent=none -o PasswordAuthentication=no -o KbdInteractiveAuthentication=no -o PreferredAuthentications=publickey"},
+		{sshAuth{Agent: "/run/agent.sock", PasswordFallback: true}, Device{},
+			"-o IdentityAgent=/run/agent.sock -o PreferredAuthentications=publickey,keyboard-interactive,password"},
+	}
+	for _, c := range cases {
+		if got := strings.Join(c.auth.sshOptions(c.device), " "); got != c.want {
+			t.Errorf("sshOptions(%+v) =\n%s\nwant\n%s", c.auth, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// errTemplate marks a device whose commands could not be rendered
+var errTemplate = errors.New("command template error")
+
+// templateData returns the values commands are rendered against: the device's
+// inventory vars plus hostname, platform, port and groups, which take
+// precedence over vars of the same name
+func templateData(device Device) map[string]interface{} {
+
+	data := map[string]interface{}{}
+	for key, value := range device.Vars {
+		data[key] = value
+	}
+	data["hostname"] = device.Hostname
+	data["platform"] = device.Platform
+	data["port"] = device.Port
+	data["groups"] = device.Groups
+	return data
+}
+
+// renderCommands renders every command as a text/template against the device,
+// e.g. "show bgp neighbor {{.peer_ip}}". A variable missing from the inventory
+// is an error rather than an empty string, so a half-rendered command is never
+// sent. All commands are rendered before any is used.
+func renderCommands(device Device, commands []string) ([]string, error) {
+
+	data := templateData(device)
+	rendered := make([]string, 0, len(commands))
+	for _, cmd := range commands {
+		// Most commands are plain text, don't pay for a template
+		if !strings.Contains(cmd, "{{") {
+			rendered = append(rendered, cmd)
+			continue
+		}
+
+		tmpl, err := template.New("command").Option("missingkey=error").Parse(cmd)
+		if err != nil {
+			return nil, fmt.Errorf("%w: parsing %q: %v", errTemplate, cmd, err)
+		}
+
+		var out strings.Builder
+		if err := tmpl.Execute(&out, data); err != nil {
+			return nil, fmt.Errorf("%w: rendering %q for %s: %v", errTemplate, cmd, device.Hostname, err)
+		}
+		rendered = append(rendered, strings.TrimSpace(out.String()))
+	}
+	return rendered, nil
+}
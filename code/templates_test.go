@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRenderCommands(t *testing.T) {
+	device := Device{
+		Hostname: "mx1",
+		Platform: "juniper_junos",
+		Vars: map[string]interface{}{
+			"peer_ip": "192.0.2.1",
+			"uplinks": []interface{}{"xe-0/0/0", "xe-0/0/1"},
+		},
+	}
+	commands := []string{
+		"show version",
+		"show bgp neighbor {{.peer_ip}}",
+		"show interfaces {{range $i, $u := .uplinks}}{{if $i}} {{end}}{{$u}}{{end}} terse",
+		"show system users | match {{.hostname}}",
+	}
+
+	got, err := renderCommands(device, commands)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"show version",
+		"show bgp neighbor 192.0.2.1",
+		"show interfaces xe-0/0/0 xe-0/0/1 terse",
+		"show system users | match mx1",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("renderCommands() = %q, want %q", got, want)
+	}
+}
+
+func TestRenderCommandsMissingVariable(t *testing.T) {
+	device := Device{Hostname: "mx1", Vars: map[string]interface{}{}}
+
+	_, err := renderCommands(device, []string{"show bgp neighbor {{.peer_ip}}"})
+	if !errors.Is(err, errTemplate) || !strings.Contains(err.Error(), "peer_ip") {
+		t.Errorf("err = %v, want template error naming peer_ip", err)
+	}
+}
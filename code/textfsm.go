@@ -0,0 +1,142 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirikothe/gotextfsm"
+)
+
+// bundledTemplates are the TextFSM templates shipped with the collector
+//
+//go:embed textfsm/*.textfsm
+var bundledTemplates embed.FS
+
+// ntcPlatforms maps scrapligo platform names to the names ntc-templates files
+// use, where they differ
+var ntcPlatforms = map[string]string{
+	"cisco_iosxe": "cisco_ios",
+	"cisco_iosxr": "cisco_xr",
+	"nokia_sros":  "alcatel_sros",
+}
+
+// textfsmParser parses command output with TextFSM templates named the
+// ntc-templates way, <platform>_<command>.textfsm, e.g.
+// juniper_junos_show_interfaces_terse.textfsm. Templates in the user's
+// directory override bundled ones of the same name, so ntc-templates files
+// can be dropped straight in.
+type textfsmParser struct {
+	// templates is keyed by file name without the extension
+	templates map[string]string
+}
+
+// loadTextFSMTemplates reads the bundled templates and then those in dir, if
+// set, checking every one compiles so a broken template fails the run up front
+func loadTextFSMTemplates(dir string) (*textfsmParser, error) {
+
+	p := &textfsmParser{templates: map[string]string{}}
+
+	bundled, err := fs.Glob(bundledTemplates, "textfsm/*.textfsm")
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range bundled {
+		content, err := bundledTemplates.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		p.templates[strings.TrimSuffix(filepath.Base(file), ".textfsm")] = string(content)
+	}
+
+	if dir != "" {
+		if _, err := os.Stat(dir); err != nil {
+			return nil, fmt.Errorf("textfsm template directory: %w", err)
+		}
+		files, err := filepath.Glob(filepath.Join(dir, "*.textfsm"))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			content, err := os.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			p.templates[strings.ToLower(strings.TrimSuffix(filepath.Base(file), ".textfsm"))] = string(content)
+		}
+	}
+
+	for name, template := range p.templates {
+		fsm := gotextfsm.TextFSM{}
+		if err := fsm.ParseString(template); err != nil {
+			return nil, fmt.Errorf("textfsm template %s: %w", name, err)
+		}
+	}
+	return p, nil
+}
+
+// templateFor returns the template for a command on a platform, if there is one
+func (p *textfsmParser) templateFor(platform string, command string) (string, bool) {
+
+	slug := strings.ToLower(slugCommand(command))
+	names := []string{platform}
+	if ntc, ok := ntcPlatforms[platform]; ok {
+		names = append(names, ntc)
+	}
+	for _, name := range names {
+		if template, ok := p.templates[name+"_"+slug]; ok {
+			return template, true
+		}
+	}
+	return "", false
+}
+
+// parseTextFSM runs output through a template and returns the rows it extracted
+func parseTextFSM(template string, output string) ([]map[string]interface{}, error) {
+
+	fsm := gotextfsm.TextFSM{}
+	if err := fsm.ParseString(template); err != nil {
+		return nil, err
+	}
+	parser := gotextfsm.ParserOutput{}
+	if err := parser.ParseTextString(output, fsm, true); err != nil {
+		return nil, err
+	}
+	return parser.Dict, nil
+}
+
+// parseRecord adds parsed rows to every successful command with a template,
+// noting a parse failure against the command rather than failing the device.
+// It returns the number of commands that failed to parse. NETCONF replies are
+// XML, which the CLI templates don't read, so those records are left alone.
+func (p *textfsmParser) parseRecord(record *deviceRecord) int {
+
+	if record.Transport == transportNetconf {
+		return 0
+	}
+	failures := 0
+	for i, result := range record.Commands {
+		if result.Error != "" {
+			continue
+		}
+		template, ok := p.templateFor(record.Platform, result.Command)
+		if !ok {
+			continue
+		}
+		parsed, err := parseTextFSM(template, result.Output)
+		switch {
+		case err != nil:
+			record.Commands[i].ParseError = err.Error()
+			failures++
+		case len(parsed) == 0:
+			record.Commands[i].ParseError = "template matched nothing in the output"
+			failures++
+		default:
+			record.Commands[i].Parsed = parsed
+		}
+	}
+	return failures
+}
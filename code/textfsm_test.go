@@ -0,0 +1,156 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const junosShowVersion = `Hostname: mx1
+Model: mx960
+Junos: 21.4R3.15
+JUNOS OS Kernel 64-bit  [20220812.c7b8fcd_builder_stable_12]
+`
+
+const junosInterfacesTerse = `Interface               Admin Link Proto    Local                 Remote
+ge-0/0/0                up    up
+ge-0/0/0.0              up    up   inet     10.0.0.1/30
+                                   inet6    fe80::1/64
+ge-0/0/1                down  down
+lo0.0                   up    up   inet     10.255.0.1          --> 0/0
+`
+
+const eosShowVersion = `Arista DCS-7050TX-64-R
+Hardware version:    01.11
+Serial number:       JPE12345678
+System MAC address:  001c.7300.0000
+
+Software image version: 4.28.3M
+Architecture:           i686
+`
+
+const iosInterfaceBrief = `Interface              IP-Address      OK? Method Status                Protocol
+GigabitEthernet1       10.0.0.1        YES NVRAM  up                    up
+GigabitEthernet2       unassigned      YES NVRAM  administratively down down
+`
+
+func TestBundledTextFSMTemplates(t *testing.T) {
+	parser, err := loadTextFSMTemplates("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		platform, command, output string
+		rows, row                 int
+		field, want               string
+	}{
+		{"juniper_junos", "show version", junosShowVersion, 1, 0, "VERSION", "21.4R3.15"},
+		{"juniper_junos", "show interfaces terse", junosInterfacesTerse, 4, 1, "LOCAL", "10.0.0.1/30"},
+		{"juniper_junos", "show interfaces terse", junosInterfacesTerse, 4, 3, "REMOTE", "0/0"},
+		{"arista_eos", "show version", eosShowVersion, 1, 0, "SERIAL", "JPE12345678"},
+		// IOS-XE devices use the ntc-templates cisco_ios name
+		{"cisco_iosxe", "show ip interface brief", iosInterfaceBrief, 2, 1, "STATUS", "administratively down"},
+	}
+	for _, c := range cases {
+		template, ok := parser.templateFor(c.platform, c.command)
+		if !ok {
+			t.Errorf("no template for %s %q", c.platform, c.command)
+			continue
+		}
+		rows, err := parseTextFSM(template, c.output)
+		if err != nil {
+			t.Errorf("%s %q: %v", c.platform, c.command, err)
+			continue
+		}
+		if len(rows) != c.rows {
+			t.Errorf("%s %q: got %d rows, want %d: %v", c.platform, c.command, len(rows), c.rows, rows)
+			continue
+		}
+		if got := rows[c.row][c.field]; got != c.want {
+			t.Errorf("%s %q: row %d %s = %v, want %s", c.platform, c.command, c.row, c.field, got, c.want)
+		}
+	}
+}
+
+func TestParseRecord(t *testing.T) {
+	dir := t.TempDir()
+	// A user template replaces the bundled one of the same name
+	override := "Value MODEL (\\S+)\n\nStart\n  ^Model:\\s+${MODEL}\n"
+	if err := os.WriteFile(filepath.Join(dir, "juniper_junos_show_version.textfsm"), []byte(override), 0644); err != nil {
+		t.Fatal(err)
+	}
+	failing := "Value UPTIME (.+)\n\nStart\n  ^System booted -> Error\n"
+	if err := os.WriteFile(filepath.Join(dir, "juniper_junos_show_system_uptime.textfsm"), []byte(failing), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	parser, err := loadTextFSMTemplates(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record := newDeviceRecord(Device{Hostname: "mx1", Platform: "juniper_junos"}, time.Now())
+	record.addCommand("show version", junosShowVersion, nil, time.Now())
+	record.addCommand("show system uptime", "System booted: 2026-10-01\n", nil, time.Now())
+	record.addCommand("show chassis alarms", "No alarms currently active\n", nil, time.Now())
+
+	if failures := parser.parseRecord(record); failures != 1 {
+		t.Errorf("parseRecord() = %d failures, want 1", failures)
+	}
+	version := record.Commands[0]
+	if len(version.Parsed) != 1 || version.Parsed[0]["MODEL"] != "mx960" || version.Parsed[0]["VERSION"] != nil {
+		t.Errorf("show version parsed = %v", version.Parsed)
+	}
+	if record.Commands[1].ParseError == "" || record.Commands[1].Parsed != nil {
+		t.Errorf("show system uptime = %+v", record.Commands[1])
+	}
+	if record.Commands[2].Parsed != nil || record.Commands[2].ParseError != "" {
+		t.Errorf("a command with no template was parsed: %+v", record.Commands[2])
+	}
+
+	netconf := newDeviceRecord(Device{Hostname: "mx2", Platform: "juniper_junos", Transport: transportNetconf}, time.Now())
+	netconf.addCommand("show version", "<rpc-reply><software-information/></rpc-reply>", nil, time.Now())
+	if failures := parser.parseRecord(netconf); failures != 0 || netconf.Commands[0].ParseError != "" {
+		t.Errorf("NETCONF record parsed: %d failures, %+v", failures, netconf.Commands[0])
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "broken.textfsm"), []byte("Value X\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadTextFSMTemplates(dir); err == nil {
+		t.Error("want an error for a broken template")
+	}
+}
+
+func TestShippedCommandsReachTemplates(t *testing.T) {
+	parser, err := loadTextFSMTemplates("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sets, err := loadCommandSets("", "commands")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every bundled template should be picked by a command some platform's
+	// shipped set sends, or -parse never uses it
+	used := map[string]bool{}
+	for _, platform := range []string{"juniper_junos", "cisco_iosxe", "arista_eos"} {
+		commands, err := sets.forDevice(Device{Platform: platform})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, command := range commands {
+			if template, ok := parser.templateFor(platform, command); ok {
+				used[template] = true
+			}
+		}
+	}
+	for name, template := range parser.templates {
+		if !used[template] {
+			t.Errorf("template %s matches no shipped command", name)
+		}
+	}
+}
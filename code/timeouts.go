@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/scrapli/scrapligo/util"
+)
+
+// errDeviceTimeout marks a device that ran past one of its time limits
+var errDeviceTimeout = errors.New("device timed out")
+
+// timeouts holds the time limits applied to every device in a run
+type timeouts struct {
+	// Connect bounds opening the SSH session (scrapligo socket timeout)
+	Connect time.Duration
+	// Command bounds each command sent to the device (scrapligo ops timeout)
+	Command time.Duration
+	// Device bounds the whole connect-and-collect cycle for one device
+	Device time.Duration
+}
+
+// deviceContext returns a context that expires after the per-device wall time
+func (t timeouts) deviceContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if t.Device <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, t.Device)
+}
+
+// runWithDeadline runs fn until it returns or ctx expires, whichever comes first.
+// When ctx expires abort is called to tear down whatever fn is blocked on and an
+// errDeviceTimeout is returned without waiting for fn any further.
+func runWithDeadline(ctx context.Context, fn func() error, abort func()) error {
+
+	// Buffered so the goroutine can always finish even if nobody is listening
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		abort()
+		return fmt.Errorf("%w: %v", errDeviceTimeout, ctx.Err())
+	}
+}
+
+// Failure classes reported in the run summary
+const (
+	classOK      = "ok"
+	classTimeout = "timeout"
+	classError   = "error"
+)
+
+// failureClass sorts a device error into one of the summary classes
+func failureClass(err error) string {
+	switch {
+	case err == nil:
+		return classOK
+	case errors.Is(err, errDeviceTimeout),
+		errors.Is(err, util.ErrTimeoutError),
+		errors.Is(err, context.DeadlineExceeded):
+		return classTimeout
+	case errors.Is(err, util.ErrConnectionError) && strings.Contains(err.Error(), "timed out"):
+		// The system ssh binary reports its ConnectTimeout as a connection error
+		return classTimeout
+	}
+	return classError
+}
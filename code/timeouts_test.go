@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/scrapli/scrapligo/util"
+)
+
+func TestRunWithDeadlineAborts(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	release := make(chan struct{})
+	aborted := false
+	err := runWithDeadline(ctx, func() error {
+		<-release
+		return nil
+	}, func() {
+		aborted = true
+		close(release)
+	})
+
+	if !errors.Is(err, errDeviceTimeout) {
+		t.Errorf("err = %v, want errDeviceTimeout", err)
+	}
+	if !aborted {
+		t.Error("abort was not called")
+	}
+}
+
+func TestRunWithDeadlineReturnsResult(t *testing.T) {
+	want := errors.New("boom")
+	err := runWithDeadline(context.Background(), func() error { return want }, func() {
+		t.Error("abort called without a deadline")
+	})
+	if err != want {
+		t.Errorf("err = %v, want %v", err, want)
+	}
+}
+
+func TestFailureClass(t *testing.T) {
+	cases := []struct {
+		err  error
+		want string
+	}{
+		{nil, classOK},
+		{fmt.Errorf("%w: context deadline exceeded", errDeviceTimeout), classTimeout},
+		{fmt.Errorf("failed to send input to device %w", util.ErrTimeoutError), classTimeout},
+		{fmt.Errorf("%w: timed out connecting to host", util.ErrConnectionError), classTimeout},
+		{fmt.Errorf("%w: permission denied", util.ErrConnectionError), classError},
+		{errors.New("boom"), classError},
+	}
+
+	for _, c := range cases {
+		if got := failureClass(c.err); got != c.want {
+			t.Errorf("failureClass(%v) = %q, want %q", c.err, got, c.want)
+		}
+	}
+}
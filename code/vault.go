@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+// vaultPassphraseEnv lets unattended runs unlock the vault without a prompt
+const vaultPassphraseEnv = "COLLECTOR_VAULT_PASSPHRASE"
+
+// scrypt cost parameters for new vaults, stored in the file so they can be
+// raised later without breaking existing vaults
+const (
+	vaultScryptN = 1 << 15
+	vaultScryptR = 8
+	vaultScryptP = 1
+	vaultKeyLen  = 32
+)
+
+// defaultVaultFile is where the vault lives unless -vault says otherwise
+func defaultVaultFile() string {
+	return expandHome("~/.configcollector.vault")
+}
+
+// vaultFile is the on-disk form of the vault: the entries are sealed with
+// AES-256-GCM under a key derived from the master passphrase with scrypt
+type vaultFile struct {
+	Version    int    `json:"version"`
+	N          int    `json:"scrypt_n"`
+	R          int    `json:"scrypt_r"`
+	P          int    `json:"scrypt_p"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// vaultEntry is one stored credential
+type vaultEntry struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// vault holds the decrypted entries, keyed by "host:<hostname>",
+// "group:<name>" or "default"
+type vault struct {
+	Entries map[string]vaultEntry `json:"entries"`
+}
+
+var errVaultLocked = errors.New("wrong vault passphrase or corrupted vault")
+
+// stdinLines is shared so consecutive secrets piped on stdin aren't lost to
+// one reader's buffer
+var stdinLines = bufio.NewReader(os.Stdin)
+
+func vaultKey(scope string, name string) string {
+	if scope == "default" {
+		return "default"
+	}
+	return scope + ":" + name
+}
+
+// openVault decrypts the vault at path. A missing file is an empty vault so
+// the first "vault add" can create it.
+func openVault(path string, passphrase string) (*vault, error) {
+
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &vault{Entries: map[string]vaultEntry{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file vaultFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if file.Version != 1 {
+		return nil, fmt.Errorf("%s: unsupported vault version %d", path, file.Version)
+	}
+
+	gcm, err := vaultCipher(passphrase, file.Salt, file.N, file.R, file.P)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, file.Nonce, file.Ciphertext, nil)
+	if err != nil {
+		return nil, errVaultLocked
+	}
+
+	v := &vault{}
+	if err := json.Unmarshal(plaintext, v); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if v.Entries == nil {
+		v.Entries = map[string]vaultEntry{}
+	}
+	return v, nil
+}
+
+// save seals the vault with a fresh salt and nonce and atomically replaces the file
+func (v *vault) save(path string, passphrase string) error {
+
+	salt := make([]byte, 16)
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	gcm, err := vaultCipher(passphrase, salt, vaultScryptN, vaultScryptR, vaultScryptP)
+	if err != nil {
+		return err
+	}
+	plaintext, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	file := vaultFile{
+		Version:    1,
+		N:          vaultScryptN,
+		R:          vaultScryptR,
+		P:          vaultScryptP,
+		Salt:       salt,
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, plaintext, nil),
+	}
+	content, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write alongside and rename so a failure never leaves half a vault behind
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".vault-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func vaultCipher(passphrase string, salt []byte, n, r, p int) (cipher.AEAD, error) {
+
+	key, err := scrypt.Key([]byte(passphrase), salt, n, r, p, vaultKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// lookup finds the most specific entry for a device: its hostname, then each
+// of its groups in order, then the default entry
+func (v *vault) lookup(device Device) (vaultEntry, string, bool) {
+
+	keys := []string{vaultKey("host", device.Hostname)}
+	for _, group := range device.Groups {
+		keys = append(keys, vaultKey("group", group))
+	}
+	keys = append(keys, vaultKey("default", ""))
+
+	for _, key := range keys {
+		if entry, ok := v.Entries[key]; ok {
+			return entry, key, true
+		}
+	}
+	return vaultEntry{}, "", false
+}
+
+// readSecret prompts for a secret on the terminal, or reads a line from stdin
+// when it isn't one, so vault commands can be scripted
+func readSecret(prompt string) (string, error) {
+
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		line, err := stdinLines.ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("reading %s from stdin: %w", strings.ToLower(strings.TrimSuffix(prompt, ": ")), err)
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	secret, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+// getVaultPassphrase returns the master passphrase, from the environment when
+// fromEnv is set and the variable is, otherwise from a prompt that asks twice
+// when a new passphrase is being set
+func getVaultPassphrase(prompt string, fromEnv bool, confirm bool) (string, error) {
+
+	if passphrase := os.Getenv(vaultPassphraseEnv); passphrase != "" && fromEnv {
+		return passphrase, nil
+	}
+
+	passphrase, err := readSecret(prompt)
+	if err != nil {
+		return "", err
+	}
+	if passphrase == "" {
+		return "", fmt.Errorf("the vault passphrase cannot be empty")
+	}
+	if confirm && term.IsTerminal(int(os.Stdin.Fd())) {
+		again, err := readSecret("Repeat passphrase: ")
+		if err != nil {
+			return "", err
+		}
+		if again != passphrase {
+			return "", fmt.Errorf("passphrases do not match")
+		}
+	}
+	return passphrase, nil
+}
+
+// runVault implements the vault subcommands:
+//
+//	vault add  -host mx1 | -group core | -default  -username netops
+//	vault list
+//	vault rm   -host mx1 | -group core | -default
+//	vault rotate
+func runVault(args []string) error {
+
+	if len(args) == 0 {
+		return fmt.Errorf("usage: vault add|list|rm|rotate [flags]")
+	}
+
+	fs := flag.NewFlagSet("vault "+args[0], flag.ExitOnError)
+	path := fs.String("vault", defaultVaultFile(), "vault file")
+	host := fs.String("host", "", "store the credential for this device")
+	group := fs.String("group", "", "store the credential for this inventory group")
+	isDefault := fs.Bool("default", false, "store the credential used when no host or group entry matches")
+	username := fs.String("username", "", "username to store")
+	fs.Parse(args[1:])
+
+	key := ""
+	switch {
+	case *host != "":
+		key = vaultKey("host", *host)
+	case *group != "":
+		key = vaultKey("group", *group)
+	case *isDefault:
+		key = vaultKey("default", "")
+	}
+
+	command := args[0]
+	if (command == "add" || command == "rm") && key == "" {
+		return fmt.Errorf("vault %s needs -host, -group or -default", command)
+	}
+	if command == "add" && *username == "" {
+		return fmt.Errorf("vault add needs -username")
+	}
+	if command != "add" && command != "list" && command != "rm" && command != "rotate" {
+		return fmt.Errorf("unknown vault command %q", command)
+	}
+
+	_, statErr := os.Stat(*path)
+	creating := os.IsNotExist(statErr)
+	if creating && command != "add" {
+		return fmt.Errorf("%s does not exist, create it with vault add", *path)
+	}
+
+	passphrase, err := getVaultPassphrase("Vault passphrase: ", true, creating)
+	if err != nil {
+		return err
+	}
+	v, err := openVault(*path, passphrase)
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "add":
+		password, err := readSecret(fmt.Sprintf("Password for %s: ", *username))
+		if err != nil {
+			return err
+		}
+		v.Entries[key] = vaultEntry{Username: *username, Password: password}
+		if err := v.save(*path, passphrase); err != nil {
+			return err
+		}
+		fmt.Printf("stored %s\n", key)
+	case "list":
+		keys := make([]string, 0, len(v.Entries))
+		for k := range v.Entries {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Printf("%-30s %s\n", k, v.Entries[k].Username)
+		}
+	case "rm":
+		if _, ok := v.Entries[key]; !ok {
+			return fmt.Errorf("%s is not in the vault", key)
+		}
+		delete(v.Entries, key)
+		if err := v.save(*path, passphrase); err != nil {
+			return err
+		}
+		fmt.Printf("removed %s\n", key)
+	case "rotate":
+		// Re-seal under a new master passphrase, which also picks a fresh salt
+		newPassphrase, err := getVaultPassphrase("New vault passphrase: ", false, true)
+		if err != nil {
+			return err
+		}
+		if err := v.save(*path, newPassphrase); err != nil {
+			return err
+		}
+		fmt.Println("vault passphrase changed")
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVaultRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vault")
+
+	v, err := openVault(path, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.Entries[vaultKey("host", "mx1")] = vaultEntry{Username: "alice", Password: "one"}
+	v.Entries[vaultKey("group", "core")] = vaultEntry{Username: "core", Password: "two"}
+	v.Entries[vaultKey("default", "")] = vaultEntry{Username: "ops", Password: "three"}
+	if err := v.save(path, "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "alice") || strings.Contains(string(content), "three") {
+		t.Error("vault file contains plaintext credentials")
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("vault permissions = %v, want 0600", info.Mode().Perm())
+	}
+
+	if _, err := openVault(path, "wrong"); !errors.Is(err, errVaultLocked) {
+		t.Errorf("wrong passphrase: err = %v, want errVaultLocked", err)
+	}
+
+	v, err = openVault(path, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		device Device
+		want   string
+	}{
+		{Device{Hostname: "mx1", Groups: []string{"core"}}, "alice"},
+		{Device{Hostname: "mx2", Groups: []string{"edge", "core"}}, "core"},
+		{Device{Hostname: "mx3"}, "ops"},
+	}
+	for _, c := range cases {
+		entry, _, ok := v.lookup(c.device)
+		if !ok || entry.Username != c.want {
+			t.Errorf("lookup(%s) = %+v, %v, want %s", c.device.Hostname, entry, ok, c.want)
+		}
+	}
+}